@@ -7,6 +7,7 @@ require (
 	github.com/minio/minio-go v6.0.14+incompatible
 	github.com/satori/go.uuid v1.2.0
 	golang.org/x/crypto v0.5.0
+	golang.org/x/image v0.18.0
 )
 
 require (
@@ -15,6 +16,6 @@ require (
 	github.com/stretchr/testify v1.8.1 // indirect
 	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
-	golang.org/x/text v0.6.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
@@ -0,0 +1,132 @@
+package albums
+
+import (
+	"fmt"
+	"project/server/config"
+	"project/server/posts"
+	"project/server/users"
+	"reflect"
+	"testing"
+)
+
+func postIds(postSlice []posts.Post) []int {
+	ids := make([]int, 0)
+	for _, post := range postSlice {
+		ids = append(ids, post.Id)
+	}
+	return ids
+}
+
+func TestAlbums(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	for i := 0; i < 3; i++ {
+		posts.CreatePost(fmt.Sprintf("image-%d", i), 1962, 1)
+	}
+	albumId, err := CreateAlbum(" Family album 1962 ", "", false, 1)
+	if err != nil {
+		t.Fatalf("Album could not be created: %v", err)
+	}
+	for _, postId := range []int{3, 1, 2, 1} {
+		if err := AddPost(albumId, postId, 1); err != nil {
+			t.Fatalf("Post could not be added: %v", err)
+		}
+	}
+	if err := AddPost(albumId, 1, 2); err == nil {
+		t.Error("Only the owner should add posts to an album.")
+	}
+	postSlice, _ := ListAlbumPosts(albumId, true)
+	if !reflect.DeepEqual(postIds(postSlice), []int{3, 1, 2}) {
+		t.Errorf("Posts should be listed in the order they were added, got %v.", postIds(postSlice))
+	}
+
+	if _, err := GetAlbum(albumId, false); err == nil {
+		t.Error("A private album should not be shown to visitors.")
+	}
+	album, err := GetAlbum(albumId, true)
+	if err != nil || album.Title != "Family album 1962" || album.Count != 3 || album.CoverURL != "image-2" {
+		t.Fatalf("Album should be shown to editors with the first post as cover: %v %v", album, err)
+	}
+	album.Public = true
+	album.CoverPostId.Int64, album.CoverPostId.Valid = 2, true
+	if err := UpdateAlbum(album); err != nil {
+		t.Fatalf("Album could not be updated: %v", err)
+	}
+	album, _ = GetAlbum(albumId, false)
+	if album.CoverURL != "image-1" {
+		t.Errorf("The pinned cover should be used, got %s.", album.CoverURL)
+	}
+
+	type Test struct {
+		Description   string
+		Order         []int
+		ExpectedError bool
+	}
+	cases := []Test{
+		{"happy flow", []int{2, 3, 1}, false},
+		{"missing post", []int{2, 3}, true},
+		{"duplicate post", []int{2, 2, 3}, true},
+		{"post not in album", []int{2, 3, 4}, true},
+	}
+	for _, c := range cases {
+		err := ReorderPosts(albumId, c.Order, 1)
+		if (err != nil) != c.ExpectedError {
+			t.Errorf("Test '%s' failed because a different error was expected.", c.Description)
+		}
+	}
+	postSlice, _ = ListAlbumPosts(albumId, true)
+	if !reflect.DeepEqual(postIds(postSlice), []int{2, 3, 1}) {
+		t.Errorf("A failed reorder should leave the order in place, got %v.", postIds(postSlice))
+	}
+
+	if err := RemovePost(albumId, 3, 1); err != nil {
+		t.Fatalf("Post could not be removed: %v", err)
+	}
+	posts.DeletePost(1, 1)
+	postSlice, _ = ListAlbumPosts(albumId, true)
+	if !reflect.DeepEqual(postIds(postSlice), []int{2}) {
+		t.Errorf("Removed and deleted posts should not be listed, got %v.", postIds(postSlice))
+	}
+	AddPost(albumId, 3, 1)
+	if err := ReorderPosts(albumId, []int{3, 1, 2}, 1); err == nil {
+		t.Error("Trashed posts should not be part of the order.")
+	}
+	if err := ReorderPosts(albumId, []int{3, 2}, 1); err != nil {
+		t.Errorf("The posts outside the trash should be reordered: %v", err)
+	}
+	posts.RestorePost(1)
+	postSlice, _ = ListAlbumPosts(albumId, true)
+	if !reflect.DeepEqual(postIds(postSlice), []int{3, 2, 1}) {
+		t.Errorf("A restored post should follow the reordered posts, got %v.", postIds(postSlice))
+	}
+	if err := DeleteAlbum(albumId, 1); err != nil {
+		t.Fatalf("Album could not be deleted: %v", err)
+	}
+	albums, _ := ListAlbums(true)
+	if len(albums) != 0 {
+		t.Error("A deleted album should not be listed.")
+	}
+}
+
+func TestPage(t *testing.T) {
+	postSlice := make([]posts.Post, 30)
+	type Test struct {
+		Offset int
+		Length int
+		First  bool
+		Last   bool
+	}
+	cases := []Test{
+		{0, 12, true, false},
+		{12, 12, false, false},
+		{24, 6, false, true},
+		{-12, 12, true, false},
+		{36, 12, true, false},
+	}
+	for _, c := range cases {
+		result, first, last := page(postSlice, 12, c.Offset)
+		if len(result) != c.Length || first != c.First || last != c.Last {
+			t.Errorf("page at offset %d = %d posts, %v, %v", c.Offset, len(result), first, last)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package albums
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"project/server/config"
+	"project/server/posts"
+	"project/server/users"
+	"strconv"
+	"strings"
+)
+
+const pageSize = 12
+
+// AlbumsHandler lists the albums and lets editors create a new one.
+func AlbumsHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Albums   []Album
+		LoggedIn bool
+	}
+	userId, loggedIn := users.GetLoginStatus(req)
+	if req.Method == http.MethodPost {
+		if !loggedIn {
+			http.Redirect(w, req, "/login", http.StatusSeeOther)
+			return
+		}
+		title := req.PostFormValue("title")
+		if strings.TrimSpace(title) == "" {
+			http.Error(w, "An album needs a title", http.StatusForbidden)
+			return
+		}
+		albumId, err := CreateAlbum(title, req.PostFormValue("description"), req.PostFormValue("public") == "on", *userId)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error creating album. Please try again or contact administrator.", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/albums/edit/"+strconv.Itoa(albumId), http.StatusSeeOther)
+		return
+	}
+	albums, err := ListAlbums(loggedIn)
+	if err != nil {
+		http.Error(w, "error retrieving albums from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Albums:   albums,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "albums.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// AlbumHandler shows the posts of an album in album order, a page at a time.
+func AlbumHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Album          Album
+		Posts          []posts.Post
+		LoggedIn       bool
+		First          bool
+		Last           bool
+		PrevProperties template.URL
+		NextProperties template.URL
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	albumId, err := strconv.Atoi(req.URL.Path[len("/albums/"):])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	album, err := GetAlbum(albumId, loggedIn)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error retrieving album from the database", http.StatusInternalServerError)
+		return
+	}
+	postSlice, err := ListAlbumPosts(albumId, loggedIn)
+	if err != nil {
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+	d := data{
+		Album:          album,
+		LoggedIn:       loggedIn,
+		PrevProperties: template.URL("offset=" + strconv.Itoa(offset-pageSize)),
+		NextProperties: template.URL("offset=" + strconv.Itoa(offset+pageSize)),
+	}
+	d.Posts, d.First, d.Last = page(postSlice, pageSize, offset)
+	err = config.TPL.ExecuteTemplate(w, "album.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// EditAlbumHandler edits the details and order of one of the user's albums.
+func EditAlbumHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Album    Album
+		Posts    []posts.Post
+		LoggedIn bool
+	}
+	userId, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	albumId, err := strconv.Atoi(req.URL.Path[len("/albums/edit/"):])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	album, err := GetAlbum(albumId, true)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error retrieving album from the database", http.StatusInternalServerError)
+		return
+	}
+	if album.UserId != *userId {
+		http.Error(w, "Album is not owned by user", http.StatusForbidden)
+		return
+	}
+	if req.Method == http.MethodPost {
+		editAlbum(w, req, album)
+		return
+	}
+	postSlice, err := ListAlbumPosts(albumId, true)
+	if err != nil {
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Album:    album,
+		Posts:    postSlice,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "albumedit.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+func editAlbum(w http.ResponseWriter, req *http.Request, album Album) {
+	var err error
+	redirect := "/albums/edit/" + strconv.Itoa(album.Id)
+	switch req.PostFormValue("action") {
+	case "save":
+		album.Title = req.PostFormValue("title")
+		album.Description = req.PostFormValue("description")
+		album.Public = req.PostFormValue("public") == "on"
+		coverPostId, convErr := strconv.Atoi(req.PostFormValue("cover"))
+		if convErr != nil || strings.TrimSpace(album.Title) == "" {
+			http.Error(w, "Malformatted title or cover", http.StatusForbidden)
+			return
+		}
+		album.CoverPostId = sql.NullInt64{Int64: int64(coverPostId), Valid: coverPostId != 0}
+		err = UpdateAlbum(album)
+	case "order":
+		var postIds []int
+		postIds, err = parseIds(req.PostFormValue("order"))
+		if err != nil {
+			http.Error(w, "Malformatted order", http.StatusForbidden)
+			return
+		}
+		err = ReorderPosts(album.Id, postIds, album.UserId)
+	case "remove":
+		postId, convErr := strconv.Atoi(req.PostFormValue("post"))
+		if convErr != nil {
+			http.Error(w, "Malformatted post id", http.StatusForbidden)
+			return
+		}
+		err = RemovePost(album.Id, postId, album.UserId)
+	case "delete":
+		err = DeleteAlbum(album.Id, album.UserId)
+		redirect = "/albums"
+	default:
+		http.Error(w, "Unknown action", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error updating album. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, req, redirect, http.StatusSeeOther)
+}
+
+// AddToAlbumHandler adds a post to one of the user's albums.
+func AddToAlbumHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Post     posts.Post
+		Albums   []Album
+		LoggedIn bool
+	}
+	userId, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	postId, err := strconv.Atoi(req.FormValue("post"))
+	if err != nil {
+		http.Error(w, "Malformatted post id", http.StatusForbidden)
+		return
+	}
+	if req.Method == http.MethodPost {
+		albumId, err := strconv.Atoi(req.PostFormValue("album"))
+		if err != nil {
+			http.Error(w, "Malformatted album id", http.StatusForbidden)
+			return
+		}
+		err = AddPost(albumId, postId, *userId)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error adding post to album. Please try again or contact administrator.", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/albums/edit/"+strconv.Itoa(albumId), http.StatusSeeOther)
+		return
+	}
+	post, err := posts.GetPost(postId)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error loading post. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	albums, err := ListUserAlbums(*userId)
+	if err != nil {
+		http.Error(w, "error retrieving albums from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Post:     post,
+		Albums:   albums,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "albumadd.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+func parseIds(value string) ([]int, error) {
+	ids := make([]int, 0)
+	for _, field := range strings.Split(value, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
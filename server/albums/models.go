@@ -0,0 +1,313 @@
+package albums
+
+import (
+	"database/sql"
+	"errors"
+	"project/server/config"
+	"project/server/posts"
+	"strings"
+	"time"
+)
+
+// Album is a hand-curated, ordered set of posts. Private albums are only
+// listed for editors.
+type Album struct {
+	Id          int
+	UserId      int
+	Title       string
+	Description string
+	CoverPostId sql.NullInt64
+	Public      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	// CoverURL is the pinned cover or else the first visible post.
+	CoverURL string
+	Count    int
+}
+
+var errNotOwner = errors.New("album does not exist or is not owned by user")
+
+// albumColumns lists the album columns in the order scanAlbum expects them.
+// The cover and count depend on what the visitor may see, so they follow
+// from a lateral join named c on the visible posts of the album.
+const albumColumns = `a.id, a.user_id, a.title, a.description, a.cover_post_id, a.public, a.created_at, a.updated_at,
+	COALESCE(c.cover_url, ''), COALESCE(c.count, 0)`
+
+func albumJoin(showAll bool) string {
+	return `LEFT JOIN LATERAL (
+			SELECT
+				(ARRAY_AGG(p.minio_url ORDER BY p.id = a.cover_post_id DESC NULLS LAST, ap.position))[1] AS cover_url,
+				COUNT(*) AS count
+			FROM album_posts ap
+			JOIN posts p ON p.id = ap.post_id
+			WHERE ap.album_id = a.id AND ` + posts.VisibleCondition(showAll) + `
+		) c ON TRUE`
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAlbum(row scanner) (Album, error) {
+	album := Album{}
+	err := row.Scan(&album.Id, &album.UserId, &album.Title, &album.Description, &album.CoverPostId, &album.Public,
+		&album.CreatedAt, &album.UpdatedAt, &album.CoverURL, &album.Count)
+	return album, err
+}
+
+func CreateAlbum(title, description string, public bool, userId int) (int, error) {
+	var albumId int
+	err := config.DB.QueryRow(`
+		INSERT INTO albums (USER_ID, TITLE, DESCRIPTION, PUBLIC) VALUES ($1, $2, $3, $4) RETURNING ID;`,
+		userId, strings.TrimSpace(title), strings.TrimSpace(description), public).Scan(&albumId)
+	return albumId, err
+}
+
+// GetAlbum returns an album; private albums only when showAll is set.
+func GetAlbum(albumId int, showAll bool) (Album, error) {
+	row := config.DB.QueryRow(`
+		SELECT `+albumColumns+`
+		FROM albums a
+		`+albumJoin(showAll)+`
+		WHERE a.id = $1 AND ($2 OR a.public);
+		`, albumId, showAll)
+	return scanAlbum(row)
+}
+
+// ListAlbums returns the albums, most recently updated first.
+func ListAlbums(showAll bool) ([]Album, error) {
+	albums := make([]Album, 0)
+	rows, err := config.DB.Query(`
+		SELECT `+albumColumns+`
+		FROM albums a
+		`+albumJoin(showAll)+`
+		WHERE $1 OR a.public
+		ORDER BY a.updated_at DESC;
+		`, showAll)
+	if err != nil {
+		return albums, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return albums, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, rows.Err()
+}
+
+// ListUserAlbums returns the albums of a user, for adding posts to them.
+func ListUserAlbums(userId int) ([]Album, error) {
+	albums := make([]Album, 0)
+	rows, err := config.DB.Query(`
+		SELECT `+albumColumns+`
+		FROM albums a
+		`+albumJoin(true)+`
+		WHERE a.user_id = $1
+		ORDER BY a.title;
+		`, userId)
+	if err != nil {
+		return albums, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		album, err := scanAlbum(rows)
+		if err != nil {
+			return albums, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, rows.Err()
+}
+
+// UpdateAlbum stores the title, description, cover and visibility of one of
+// the user's albums.
+func UpdateAlbum(album Album) error {
+	result, err := config.DB.Exec(`
+		UPDATE albums
+		SET UPDATED_AT=NOW(),
+			TITLE=$1,
+			DESCRIPTION=$2,
+			COVER_POST_ID=$3,
+			PUBLIC=$4
+		WHERE ID=$5 AND USER_ID=$6;
+		`,
+		strings.TrimSpace(album.Title), strings.TrimSpace(album.Description), album.CoverPostId, album.Public, album.Id, album.UserId)
+	return ownedResult(result, err)
+}
+
+func DeleteAlbum(albumId, userId int) error {
+	result, err := config.DB.Exec("DELETE FROM albums WHERE id=$1 AND user_id=$2;", albumId, userId)
+	return ownedResult(result, err)
+}
+
+func ownedResult(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errNotOwner
+	}
+	return nil
+}
+
+func checkOwner(tx *sql.Tx, albumId, userId int) error {
+	var owned bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM albums WHERE id=$1 AND user_id=$2);", albumId, userId).Scan(&owned)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return errNotOwner
+	}
+	return nil
+}
+
+// AddPost appends a post to the end of one of the user's albums. Adding a
+// post that is already in the album leaves it in place.
+func AddPost(albumId, postId, userId int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkOwner(tx, albumId, userId); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO album_posts (album_id, post_id, position)
+		SELECT $1, $2, COALESCE(MAX(position), 0) + 1 FROM album_posts WHERE album_id = $1
+		ON CONFLICT (album_id, post_id) DO NOTHING;
+		`, albumId, postId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE albums SET updated_at=NOW() WHERE id=$1;", albumId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func RemovePost(albumId, postId, userId int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkOwner(tx, albumId, userId); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM album_posts WHERE album_id=$1 AND post_id=$2;", albumId, postId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE albums SET updated_at=NOW() WHERE id=$1;", albumId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReorderPosts puts the posts of one of the user's albums in the given
+// order. The ids have to be exactly the posts in the album that are not in
+// the trash; trashed posts keep their order after them.
+func ReorderPosts(albumId int, postIds []int, userId int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := checkOwner(tx, albumId, userId); err != nil {
+		return err
+	}
+	var count int
+	err = tx.QueryRow(`
+		SELECT COUNT(*)
+		FROM album_posts ap
+		JOIN posts p ON p.id = ap.post_id
+		WHERE ap.album_id=$1 AND p.deleted_at IS NULL;
+		`, albumId).Scan(&count)
+	if err != nil {
+		return err
+	}
+	seen := make(map[int]bool, len(postIds))
+	for _, postId := range postIds {
+		seen[postId] = true
+	}
+	if count != len(postIds) || len(seen) != len(postIds) {
+		return errors.New("order does not match the posts in the album")
+	}
+	for i, postId := range postIds {
+		result, err := tx.Exec(`
+			UPDATE album_posts SET position=$1
+			WHERE album_id=$2 AND post_id=$3
+			AND post_id IN (SELECT id FROM posts WHERE deleted_at IS NULL);
+			`, i+1, albumId, postId)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return errors.New("order does not match the posts in the album")
+		}
+	}
+	// restored posts come back after the ones that were reordered
+	_, err = tx.Exec(`
+		UPDATE album_posts ap SET position = $2 + trashed.rank
+		FROM (
+			SELECT ap.post_id, ROW_NUMBER() OVER (ORDER BY ap.position, ap.post_id) AS rank
+			FROM album_posts ap
+			JOIN posts p ON p.id = ap.post_id
+			WHERE ap.album_id=$1 AND p.deleted_at IS NOT NULL
+		) trashed
+		WHERE ap.album_id=$1 AND ap.post_id = trashed.post_id;
+		`, albumId, len(postIds))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE albums SET updated_at=NOW() WHERE id=$1;", albumId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListAlbumPosts returns the visible posts of an album in album order.
+func ListAlbumPosts(albumId int, showAll bool) ([]posts.Post, error) {
+	var postIds []int
+	rows, err := config.DB.Query("SELECT post_id FROM album_posts WHERE album_id=$1 ORDER BY position;", albumId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postId int
+		if err := rows.Scan(&postId); err != nil {
+			return nil, err
+		}
+		postIds = append(postIds, postId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return posts.ListPostsById(postIds, showAll)
+}
+
+// page returns the posts on the page starting at offset, and whether it is
+// the first and the last page.
+func page(postSlice []posts.Post, limit, offset int) ([]posts.Post, bool, bool) {
+	if offset < 0 || offset >= len(postSlice) {
+		offset = 0
+	}
+	end := offset + limit
+	if end >= len(postSlice) {
+		return postSlice[offset:], offset == 0, true
+	}
+	return postSlice[offset:end], offset == 0, false
+}
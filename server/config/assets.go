@@ -0,0 +1,40 @@
+package config
+
+import "strings"
+
+// Asset is a third-party script or style sheet. Integrity is its Subresource
+// Integrity hash, which the browser checks before using it.
+type Asset struct {
+	URL       string
+	Integrity string
+}
+
+// assets are the third-party files the pages load, from a CDN by default.
+// Each can be replaced, e.g. by a copy in public/vendor served at /vendor/,
+// with <NAME>_URL and <NAME>_INTEGRITY.
+var assets = map[string]Asset{
+	"leaflet.css": envAsset("LEAFLET_CSS", "https://unpkg.com/leaflet@1.9.4/dist/leaflet.css", "sha256-p4NxAoJBhIIN+hmNHrzRCf9tD/miZyoHS5obTRR9BMY="),
+	"leaflet.js":  envAsset("LEAFLET_JS", "https://unpkg.com/leaflet@1.9.4/dist/leaflet.js", "sha256-20nQCchB9co0qIjJZRGuk2/Z9VM+kNiyxNV1lvTlZBo="),
+	// pinned to a release, so a hash set with TUS_JS_INTEGRITY keeps matching;
+	// without one the script is not loaded, see Trusted
+	"tus.js": envAsset("TUS_JS", "https://cdn.jsdelivr.net/npm/tus-js-client@3.1.3/dist/tus.min.js", ""),
+}
+
+// Trusted reports whether the browser may run the asset: its integrity is
+// checked, or the archive serves it itself.
+func (a Asset) Trusted() bool {
+	sameOrigin := strings.HasPrefix(a.URL, "/") && !strings.HasPrefix(a.URL, "//")
+	return a.Integrity != "" || sameOrigin
+}
+
+func envAsset(name, url, integrity string) Asset {
+	return Asset{
+		URL:       envString(name+"_URL", url),
+		Integrity: envString(name+"_INTEGRITY", integrity),
+	}
+}
+
+// asset returns the asset templates load with the given name.
+func asset(name string) Asset {
+	return assets[name]
+}
@@ -21,5 +21,9 @@ func init() {
 	if err = DB.Ping(); err != nil {
 		panic(err)
 	}
+
+	if err = migrate(); err != nil {
+		panic(err)
+	}
 	log.Println("You connected to your database.")
 }
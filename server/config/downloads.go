@@ -0,0 +1,12 @@
+package config
+
+import "time"
+
+// DownloadSecret signs the links to original images, set with
+// DOWNLOAD_SECRET. Without it links are signed with a random secret and stop
+// working when the server restarts. DownloadLinkValidity is how long new links
+// work, set in hours with DOWNLOAD_LINK_HOURS.
+var (
+	DownloadSecret       = envString("DOWNLOAD_SECRET", "")
+	DownloadLinkValidity = time.Duration(envInt64("DOWNLOAD_LINK_HOURS", 72)) * time.Hour
+)
@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+// GCGrace protects objects younger than GC_GRACE_HOURS from the garbage
+// collector, so uploads that are still being turned into posts survive. The
+// daily collection only reports orphans unless GC_DRY_RUN is false.
+var (
+	GCGrace  = time.Duration(envInt64("GC_GRACE_HOURS", 24)) * time.Hour
+	GCDryRun = envBool("GC_DRY_RUN", true)
+)
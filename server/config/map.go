@@ -0,0 +1,8 @@
+package config
+
+// MapTileURL is the tile layer of the map page, so a self-hosted tile server
+// can replace OpenStreetMap. MapAttribution credits its map data.
+var (
+	MapTileURL     = envString("MAP_TILE_URL", "https://tile.openstreetmap.org/{z}/{x}/{y}.png")
+	MapAttribution = envString("MAP_ATTRIBUTION", `&copy; <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a> contributors`)
+)
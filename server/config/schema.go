@@ -0,0 +1,156 @@
+package config
+
+// migrations bring an existing database up to date with the tables and
+// columns added after the initial users, posts, tags and tagmap tables. They
+// all run on every start-up, so each statement has to be idempotent.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS tus_uploads (
+		id UUID PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		object_name TEXT NOT NULL,
+		multipart_id TEXT NOT NULL,
+		upload_length BIGINT NOT NULL,
+		upload_offset BIGINT NOT NULL DEFAULT 0,
+		etags TEXT[] NOT NULL DEFAULT '{}',
+		metadata TEXT NOT NULL DEFAULT '',
+		post_id INTEGER REFERENCES posts(id) ON DELETE SET NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'published';`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS publish_at TIMESTAMP;`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id);`,
+	`CREATE TABLE IF NOT EXISTS revisions (
+		id SERIAL PRIMARY KEY,
+		post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		before JSONB NOT NULL,
+		after JSONB NOT NULL
+	);`,
+	`CREATE TABLE IF NOT EXISTS scrub_results (
+		object_name TEXT PRIMARY KEY,
+		post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+		status TEXT NOT NULL,
+		expected_hash TEXT NOT NULL DEFAULT '',
+		actual_hash TEXT NOT NULL DEFAULT '',
+		message TEXT NOT NULL DEFAULT '',
+		checked_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE TABLE IF NOT EXISTS tag_audit (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		action TEXT NOT NULL,
+		tag TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);`,
+	`ALTER TABLE tags ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT 'subject';`,
+	`ALTER TABLE tags ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES tags(id) ON DELETE SET NULL;`,
+	`CREATE TABLE IF NOT EXISTS tag_aliases (
+		alias TEXT PRIMARY KEY,
+		tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE
+	);`,
+	`ALTER TABLE tags ADD COLUMN IF NOT EXISTS cover_post_id INTEGER REFERENCES posts(id) ON DELETE SET NULL;`,
+	`ALTER TABLE tags ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE tags ADD COLUMN IF NOT EXISTS display_order INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE IF NOT EXISTS albums (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		cover_post_id INTEGER REFERENCES posts(id) ON DELETE SET NULL,
+		public BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE TABLE IF NOT EXISTS album_posts (
+		album_id INTEGER NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+		post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (album_id, post_id)
+	);`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS date_precision TEXT NOT NULL DEFAULT 'year';`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS date_start DATE;`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS date_end DATE;`,
+	`UPDATE posts SET date_start = make_date(year, 1, 1), date_end = make_date(year, 12, 31)
+		WHERE date_start IS NULL AND year > 0;`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;`,
+	`ALTER TABLE posts ADD COLUMN IF NOT EXISTS place_name TEXT NOT NULL DEFAULT '';`,
+	`CREATE INDEX IF NOT EXISTS posts_location ON posts (latitude, longitude) WHERE latitude IS NOT NULL;`,
+	`CREATE TABLE IF NOT EXISTS people (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE TABLE IF NOT EXISTS regions (
+		id SERIAL PRIMARY KEY,
+		post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		person_id INTEGER NOT NULL REFERENCES people(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		x DOUBLE PRECISION NOT NULL,
+		y DOUBLE PRECISION NOT NULL,
+		width DOUBLE PRECISION NOT NULL,
+		height DOUBLE PRECISION NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);`,
+	`CREATE INDEX IF NOT EXISTS regions_post ON regions (post_id);`,
+	`CREATE INDEX IF NOT EXISTS regions_person ON regions (person_id);`,
+	`CREATE TABLE IF NOT EXISTS post_provenance (
+		post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+		film_roll TEXT NOT NULL DEFAULT '',
+		negative_number TEXT NOT NULL DEFAULT '',
+		box TEXT NOT NULL DEFAULT '',
+		scanner TEXT NOT NULL DEFAULT '',
+		scanned_on DATE,
+		resolution INTEGER
+	);`,
+	`CREATE TABLE IF NOT EXISTS post_rights (
+		post_id INTEGER PRIMARY KEY REFERENCES posts(id) ON DELETE CASCADE,
+		holder TEXT NOT NULL DEFAULT '',
+		license TEXT NOT NULL DEFAULT '',
+		credit TEXT NOT NULL DEFAULT '',
+		usage_notes TEXT NOT NULL DEFAULT ''
+	);`,
+	`CREATE TABLE IF NOT EXISTS license_requests (
+		id SERIAL PRIMARY KEY,
+		token TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL,
+		organisation TEXT NOT NULL DEFAULT '',
+		intended_use TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'new',
+		quote TEXT NOT NULL DEFAULT '',
+		notes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		delivered_at TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS license_requests_status ON license_requests (status, created_at);`,
+	`CREATE TABLE IF NOT EXISTS license_request_posts (
+		request_id INTEGER NOT NULL REFERENCES license_requests(id) ON DELETE CASCADE,
+		post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		PRIMARY KEY (request_id, post_id)
+	);`,
+	`ALTER TABLE tus_uploads ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;`,
+	`UPDATE tus_uploads SET completed_at = created_at WHERE post_id IS NOT NULL AND completed_at IS NULL;`,
+	// values stored without a time zone are read in the one of the database,
+	// as the scheduler compared them with NOW()
+	`ALTER TABLE posts ALTER COLUMN publish_at TYPE TIMESTAMPTZ;`,
+	`CREATE TABLE IF NOT EXISTS featured_posts (
+		day DATE PRIMARY KEY,
+		post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE
+	);`,
+}
+
+func migrate() error {
+	for _, migration := range migrations {
+		if _, err := DB.Exec(migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+// ScrubInterval is how often every object is re-read to verify its hash, set
+// in hours with SCRUB_INTERVAL_HOURS. Zero disables the background scrub.
+var ScrubInterval = time.Duration(envInt64("SCRUB_INTERVAL_HOURS", 168)) * time.Hour
+
+// MetricsNetworks are the comma separated networks, set with
+// METRICS_ALLOWED_NETWORKS, that may read /metrics without logging in.
+var MetricsNetworks = envString("METRICS_ALLOWED_NETWORKS", "127.0.0.0/8,::1/128")
@@ -4,10 +4,13 @@ import (
 	"html/template"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 var fm = template.FuncMap{
-	"add": func(a, b int) int { return a + b },
+	"add":   func(a, b int) int { return a + b },
+	"join":  strings.Join,
+	"asset": asset,
 }
 var TPL = template.New("public").Funcs(fm)
 
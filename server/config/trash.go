@@ -0,0 +1,7 @@
+package config
+
+import "time"
+
+// TrashRetention is how long deleted posts stay in the trash before they are
+// purged, set in days with TRASH_RETENTION_DAYS.
+var TrashRetention = time.Duration(envInt64("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
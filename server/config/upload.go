@@ -0,0 +1,14 @@
+package config
+
+import "time"
+
+// MaxFileSize and MaxRequestSize limit uploads in bytes and can be overridden
+// with UPLOAD_MAX_FILE_SIZE and UPLOAD_MAX_REQUEST_SIZE.
+var (
+	MaxFileSize    = envInt64("UPLOAD_MAX_FILE_SIZE", 250<<20)
+	MaxRequestSize = envInt64("UPLOAD_MAX_REQUEST_SIZE", 2<<30)
+)
+
+// TusUploadExpiry is how long a resumable upload may take, set in hours with
+// TUS_UPLOAD_EXPIRY_HOURS. Uploads not completed by then are removed.
+var TusUploadExpiry = time.Duration(envInt64("TUS_UPLOAD_EXPIRY_HOURS", 24)) * time.Hour
@@ -0,0 +1,18 @@
+package config
+
+// Visitors get display derivatives of the images instead of the originals:
+// reduced to DISPLAY_MAX_SIZE pixels on their longest side, 0 to keep the full
+// size, and marked with WATERMARK_TEXT or the PNG at WATERMARK_IMAGE. The mark
+// is placed at WATERMARK_POSITION with an opacity of WATERMARK_OPACITY percent.
+// Originals of more than DISPLAY_MAX_PIXELS pixels are not rendered at all,
+// and at most RENDER_SLOTS are rendered at once: each takes up to four bytes a
+// pixel of memory, 400 MB for the defaults.
+var (
+	DisplayMaxSize    = int(envInt64("DISPLAY_MAX_SIZE", 2048))
+	DisplayMaxPixels  = envInt64("DISPLAY_MAX_PIXELS", 50_000_000)
+	RenderSlots       = int(envInt64("RENDER_SLOTS", 2))
+	WatermarkText     = envString("WATERMARK_TEXT", "")
+	WatermarkImage    = envString("WATERMARK_IMAGE", "")
+	WatermarkPosition = envString("WATERMARK_POSITION", "bottom-right")
+	WatermarkOpacity  = int(envInt64("WATERMARK_OPACITY", 50))
+)
@@ -0,0 +1,200 @@
+package derivatives
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		size     image.Point
+		maxSize  int
+		expected image.Point
+	}{
+		{image.Pt(6000, 4000), 2048, image.Pt(2048, 1365)},
+		{image.Pt(4000, 6000), 2048, image.Pt(1365, 2048)},
+		{image.Pt(800, 600), 2048, image.Pt(800, 600)},
+		{image.Pt(6000, 4000), 0, image.Pt(6000, 4000)},
+		{image.Pt(10000, 2), 100, image.Pt(100, 1)},
+	}
+	for _, test := range tests {
+		if size := fit(test.size, test.maxSize); size != test.expected {
+			t.Errorf("Expected %v to fit %d as %v, got %v", test.size, test.maxSize, test.expected, size)
+		}
+	}
+}
+
+func TestPlace(t *testing.T) {
+	bounds := image.Rect(0, 0, 1000, 500)
+	size := image.Pt(100, 50)
+	tests := map[string]image.Point{
+		TopLeft:     image.Pt(10, 10),
+		TopRight:    image.Pt(890, 10),
+		BottomLeft:  image.Pt(10, 440),
+		BottomRight: image.Pt(890, 440),
+		Center:      image.Pt(450, 225),
+	}
+	for position, expected := range tests {
+		if at := place(bounds, size, position); at != expected {
+			t.Errorf("Expected %s at %v, got %v", position, expected, at)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	red := color.RGBA{R: 0xFF, A: 0xFF}
+	img.Set(0, 0, red)
+	// where the top left pixel ends up for each orientation
+	tests := map[int]image.Point{
+		1: image.Pt(0, 0),
+		2: image.Pt(2, 0),
+		3: image.Pt(2, 1),
+		4: image.Pt(0, 1),
+		5: image.Pt(0, 0),
+		6: image.Pt(1, 0),
+		7: image.Pt(1, 2),
+		8: image.Pt(0, 2),
+	}
+	for orientation, expected := range tests {
+		oriented := orient(img, orientation)
+		if orientation >= 5 && oriented.Bounds().Size() != image.Pt(2, 3) {
+			t.Errorf("Orientation %d should swap width and height, got %v", orientation, oriented.Bounds())
+		}
+		if oriented.RGBAAt(expected.X, expected.Y) != red {
+			t.Errorf("Orientation %d should move the top left pixel to %v", orientation, expected)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	var original bytes.Buffer
+	gray := image.NewGray(image.Rect(0, 0, 400, 200))
+	for i := range gray.Pix {
+		gray.Pix[i] = 0x20
+	}
+	png.Encode(&original, gray)
+
+	var clean, marked bytes.Buffer
+	if err := Render(&clean, bytes.NewReader(original.Bytes()), 100, 0, Watermark{}); err != nil {
+		t.Fatal(err)
+	}
+	wm := Watermark{Text: "© Archief", Position: BottomRight, Opacity: 1}
+	if err := Render(&marked, bytes.NewReader(original.Bytes()), 100, 0, wm); err != nil {
+		t.Fatal(err)
+	}
+	if err := Render(io.Discard, bytes.NewReader(original.Bytes()), 100, 400*200-1, wm); err != ErrTooLarge {
+		t.Errorf("Images of more than the maximum pixels should be refused, got %v.", err)
+	}
+	cleanImage, err := jpeg.Decode(&clean)
+	if err != nil || cleanImage.Bounds().Size() != image.Pt(100, 50) {
+		t.Fatalf("Expected a 100x50 JPEG, got %v", err)
+	}
+	markedImage, err := jpeg.Decode(&marked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brightest := func(img image.Image, r image.Rectangle) uint32 {
+		var max uint32
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if v, _, _, _ := img.At(x, y).RGBA(); v > max {
+					max = v
+				}
+			}
+		}
+		return max
+	}
+	corner := image.Rect(60, 30, 100, 50)
+	if brightest(cleanImage, corner) > 0x4000 {
+		t.Error("Without a watermark the derivative should stay dark.")
+	}
+	if brightest(markedImage, corner) < 0x8000 {
+		t.Error("The watermark text should show in the bottom right corner.")
+	}
+	if brightest(markedImage, image.Rect(0, 0, 40, 20)) > 0x4000 {
+		t.Error("The watermark should stay in its corner.")
+	}
+}
+
+func TestLockDerivative(t *testing.T) {
+	var mu sync.Mutex
+	var inside, most int
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := lockDerivative("derivatives/2022/abc.jpg/v.jpg")
+			mu.Lock()
+			inside++
+			if inside > most {
+				most = inside
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			inside--
+			mu.Unlock()
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if most != 1 {
+		t.Errorf("Only one render of a derivative should run at a time, got %d.", most)
+	}
+	if len(rendering) != 0 {
+		t.Errorf("Locks should be forgotten once released, %d left.", len(rendering))
+	}
+}
+
+func TestRecentFailure(t *testing.T) {
+	name := "derivatives/2022/abc.jpg/v.jpg"
+	if err := recentFailure(name); err != nil {
+		t.Errorf("Nothing failed yet, got %v", err)
+	}
+	rememberFailure(name, ErrTooLarge)
+	if err := recentFailure(name); err != ErrTooLarge {
+		t.Errorf("The failure should be remembered, got %v", err)
+	}
+	failures[name] = failedRender{ErrTooLarge, time.Now().Add(-failureRetry)}
+	if err := recentFailure(name); err != nil {
+		t.Errorf("An old failure should be tried again, got %v", err)
+	}
+	if len(failures) != 0 {
+		t.Errorf("Old failures should be forgotten, %d left.", len(failures))
+	}
+}
+
+func TestLoadWatermark(t *testing.T) {
+	overlay := filepath.Join(t.TempDir(), "mark.png")
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 20, 10)))
+	os.WriteFile(overlay, buf.Bytes(), 0o644)
+
+	wm, err := LoadWatermark("", overlay, TopLeft, 40)
+	if err != nil || wm.Overlay == nil || wm.Opacity != 0.4 || !wm.Enabled() {
+		t.Errorf("Expected an overlay at 40%% opacity, got %+v %v", wm, err)
+	}
+	if _, err := LoadWatermark("Archief", "", "middle", 40); err == nil {
+		t.Error("Unknown positions should be rejected.")
+	}
+	if _, err := LoadWatermark("", filepath.Join(t.TempDir(), "missing.png"), Center, 40); err == nil {
+		t.Error("A missing overlay should be reported.")
+	}
+	if wm, _ := LoadWatermark("Archief", "", Center, 0); wm.Enabled() {
+		t.Error("A watermark without opacity should be disabled.")
+	}
+	if settingsVersion(2048, wm) == settingsVersion(1024, wm) {
+		t.Error("Changing the size should change the version of the derivatives.")
+	}
+}
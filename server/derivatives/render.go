@@ -0,0 +1,120 @@
+// Package derivatives renders the versions of the images shown to visitors:
+// reduced in size and watermarked, so the originals stay with the archive.
+package derivatives
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"project/server/imagemeta"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// quality is the JPEG quality of the derivatives.
+const quality = 85
+
+// ErrTooLarge is returned for images with more pixels than a derivative may
+// be rendered from.
+var ErrTooLarge = errors.New("image has too many pixels to render")
+
+// Render writes the derivative of the image in r to w as a JPEG: upright, at
+// most maxSize pixels on its longest side, or the full size when maxSize is
+// zero, and with the watermark burned in. Images of more than maxPixels are
+// refused before they are decoded, unless maxPixels is zero.
+func Render(w io.Writer, r io.Reader, maxSize int, maxPixels int64, wm Watermark) error {
+	src := bufio.NewReaderSize(r, imagemeta.HeaderSize)
+	header, _ := src.Peek(imagemeta.HeaderSize)
+	orientation := imagemeta.ReadOrientation(header)
+	// keep what DecodeConfig reads to decode the image from the start
+	var read bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(src, &read))
+	if err != nil {
+		return err
+	}
+	if maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return ErrTooLarge
+	}
+	img, _, err := image.Decode(io.MultiReader(&read, src))
+	if err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	size := fit(bounds.Size(), maxSize)
+	scaled := image.NewRGBA(image.Rectangle{Max: size})
+	// JPEG has no transparency, transparent images go on white
+	draw.Draw(scaled, scaled.Bounds(), image.White, image.Point{}, draw.Src)
+	if size == bounds.Size() {
+		draw.Draw(scaled, scaled.Bounds(), img, bounds.Min, draw.Over)
+	} else {
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Over, nil)
+	}
+	derivative := orient(scaled, orientation)
+	if err := wm.Draw(derivative); err != nil {
+		return err
+	}
+	return jpeg.Encode(w, derivative, &jpeg.Options{Quality: quality})
+}
+
+// fit scales size down so its longest side is at most maxSize.
+func fit(size image.Point, maxSize int) image.Point {
+	if maxSize <= 0 || (size.X <= maxSize && size.Y <= maxSize) {
+		return size
+	}
+	if size.X >= size.Y {
+		return image.Pt(maxSize, atLeastOne(size.Y*maxSize/size.X))
+	}
+	return image.Pt(atLeastOne(size.X*maxSize/size.Y), maxSize)
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// orient turns an image the way its EXIF orientation says it should be
+// shown, as the derivatives carry no EXIF to tell browsers.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	size := image.Pt(w, h)
+	if orientation >= 5 {
+		size = image.Pt(h, w)
+	}
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], img.Pix[img.PixOffset(sx, sy):img.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
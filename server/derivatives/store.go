@@ -0,0 +1,223 @@
+package derivatives
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"project/server/config"
+	"project/server/objects"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go"
+)
+
+// LoadWatermark builds a watermark from its settings, reading the overlay
+// from the PNG at overlayPath when it is set. Opacity is in percent.
+func LoadWatermark(text, overlayPath, position string, opacity int) (Watermark, error) {
+	wm := Watermark{Text: text, Position: position, Opacity: float64(opacity) / 100}
+	if !validPosition(position) {
+		return wm, fmt.Errorf("unknown watermark position %q", position)
+	}
+	if overlayPath != "" {
+		file, err := os.Open(overlayPath)
+		if err != nil {
+			return wm, err
+		}
+		defer file.Close()
+		wm.Overlay, err = png.Decode(file)
+		if err != nil {
+			return wm, fmt.Errorf("watermark %s: %v", overlayPath, err)
+		}
+	}
+	return wm, nil
+}
+
+var (
+	loadOnce  sync.Once
+	watermark Watermark
+	version   string
+)
+
+// configured returns the watermark set in the configuration and the version
+// of the derivatives rendered with it. A watermark that fails to load is
+// logged and left out.
+func configured() (Watermark, string) {
+	loadOnce.Do(func() {
+		var err error
+		watermark, err = LoadWatermark(config.WatermarkText, config.WatermarkImage, config.WatermarkPosition, config.WatermarkOpacity)
+		if err != nil {
+			log.Printf("ignoring watermark: %v", err)
+			watermark = Watermark{}
+		}
+		version = settingsVersion(config.DisplayMaxSize, watermark)
+	})
+	return watermark, version
+}
+
+// settingsVersion names the settings the derivatives are rendered with, so
+// changing them renders the derivatives anew.
+func settingsVersion(maxSize int, wm Watermark) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%g\x00", maxSize, wm.Text, wm.Position, wm.Opacity)
+	if wm.Overlay != nil {
+		png.Encode(h, wm.Overlay)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:12]
+}
+
+// renderSlots limits how many derivatives are rendered at once, as each holds
+// a decoded original of up to four bytes a pixel in memory.
+var renderSlots = make(chan struct{}, config.RenderSlots)
+
+// failureRetry is how long a failed render is remembered, so an original that
+// can not be rendered is not decoded again on every view.
+const failureRetry = time.Hour
+
+type failedRender struct {
+	err error
+	at  time.Time
+}
+
+var (
+	failuresMu sync.Mutex
+	failures   = map[string]failedRender{}
+)
+
+// rememberFailure keeps the error rendering a derivative failed with.
+func rememberFailure(name string, err error) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	failures[name] = failedRender{err, time.Now()}
+}
+
+// recentFailure returns the error a derivative failed to render with, if that
+// was less than failureRetry ago.
+func recentFailure(name string) error {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	failure, ok := failures[name]
+	if !ok {
+		return nil
+	}
+	if time.Since(failure.at) >= failureRetry {
+		delete(failures, name)
+		return nil
+	}
+	return failure.err
+}
+
+// namedLock is held while a derivative is rendered, by as many callers as
+// are waiting for it.
+type namedLock struct {
+	sync.Mutex
+	waiters int
+}
+
+var (
+	renderingMu sync.Mutex
+	rendering   = map[string]*namedLock{}
+)
+
+// lockDerivative serialises the renders of a derivative, so visitors asking
+// for it at once wait for a single render. It returns the unlock function.
+func lockDerivative(name string) func() {
+	renderingMu.Lock()
+	lock, ok := rendering[name]
+	if !ok {
+		lock = &namedLock{}
+		rendering[name] = lock
+	}
+	lock.waiters++
+	renderingMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		renderingMu.Lock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(rendering, name)
+		}
+		renderingMu.Unlock()
+	}
+}
+
+// Open returns the derivative of an object, rendering and storing it the
+// first time it is asked for.
+func Open(minioClient *minio.Client, objectName string) (io.ReadCloser, error) {
+	wm, version := configured()
+	name := objects.DerivativesOf(objectName) + version + ".jpg"
+	cached, err := openCached(minioClient, name)
+	if cached != nil || err != nil {
+		return cached, err
+	}
+	unlock := lockDerivative(name)
+	defer unlock()
+	// another request may have rendered it while we waited
+	cached, err = openCached(minioClient, name)
+	if cached != nil || err != nil {
+		return cached, err
+	}
+	// or failed to, which is not tried again for a while
+	if err := recentFailure(name); err != nil {
+		return nil, err
+	}
+
+	original, err := minioClient.GetObject(objects.Bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer original.Close()
+	var buf bytes.Buffer
+	renderSlots <- struct{}{}
+	err = Render(&buf, original, config.DisplayMaxSize, config.DisplayMaxPixels, wm)
+	<-renderSlots
+	if err != nil {
+		err = fmt.Errorf("rendering derivative of %s: %w", objectName, err)
+		rememberFailure(name, err)
+		return nil, err
+	}
+	_, err = minioClient.PutObject(objects.Bucket, name, bytes.NewReader(buf.Bytes()), int64(buf.Len()), minio.PutObjectOptions{ContentType: "image/jpeg"})
+	if err != nil {
+		return nil, err
+	}
+	// the derivatives of earlier settings are no longer served
+	if err := objects.RemoveDerivatives(minioClient, objectName, name); err != nil {
+		log.Printf("error removing old derivatives of %s: %v", objectName, err)
+	}
+	return io.NopCloser(&buf), nil
+}
+
+// openCached returns the stored derivative with the given name, or nil when
+// it has not been rendered yet.
+func openCached(minioClient *minio.Client, name string) (io.ReadCloser, error) {
+	_, err := minioClient.StatObject(objects.Bucket, name, minio.StatObjectOptions{})
+	if err == nil {
+		return minioClient.GetObject(objects.Bucket, name, minio.GetObjectOptions{})
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Prerender renders the derivative of a new object ahead of its first
+// visitor. Failures are only logged: Open tries again when it is asked for.
+func Prerender(objectName string) {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		log.Printf("error creating minio client: %v", err)
+		return
+	}
+	derivative, err := Open(minioClient, objectName)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	derivative.Close()
+}
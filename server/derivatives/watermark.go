@@ -0,0 +1,149 @@
+package derivatives
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The positions a watermark can take on an image.
+const (
+	TopLeft     = "top-left"
+	TopRight    = "top-right"
+	BottomLeft  = "bottom-left"
+	BottomRight = "bottom-right"
+	Center      = "center"
+)
+
+var positions = []string{TopLeft, TopRight, BottomLeft, BottomRight, Center}
+
+// Watermark is burned into the derivatives shown to visitors. An overlay
+// takes the place of the text.
+type Watermark struct {
+	Text    string
+	Overlay image.Image
+	// Position is one of the positions, such as BottomRight.
+	Position string
+	// Opacity runs from 0, invisible, to 1.
+	Opacity float64
+}
+
+// Enabled reports whether there is anything to burn in.
+func (wm Watermark) Enabled() bool {
+	return (wm.Text != "" || wm.Overlay != nil) && wm.Opacity > 0
+}
+
+func validPosition(position string) bool {
+	for _, p := range positions {
+		if p == position {
+			return true
+		}
+	}
+	return false
+}
+
+// markWidth is the share of the image width the mark may cover.
+const markWidth = 0.4
+
+// Draw burns the watermark into img. The mark scales with the image, so it
+// reads the same on every size.
+func (wm Watermark) Draw(img draw.Image) error {
+	if !wm.Enabled() {
+		return nil
+	}
+	bounds := img.Bounds()
+	mark := wm.Overlay
+	if mark == nil {
+		var err error
+		mark, err = textMark(wm.Text, bounds.Dy()/20)
+		if err != nil {
+			return err
+		}
+	}
+	// shrink marks that would cover too much of the image
+	size := mark.Bounds().Size()
+	if maxWidth := int(markWidth * float64(bounds.Dx())); size.X > maxWidth && maxWidth > 0 {
+		size = image.Pt(maxWidth, size.Y*maxWidth/size.X)
+		scaled := image.NewRGBA(image.Rectangle{Max: size})
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), mark, mark.Bounds(), draw.Src, nil)
+		mark = scaled
+	}
+	at := place(bounds, size, wm.Position)
+	alpha := uint8(clamp(wm.Opacity, 0, 1) * 0xFF)
+	draw.DrawMask(img, image.Rectangle{Min: at, Max: at.Add(size)}, mark, mark.Bounds().Min,
+		image.NewUniform(color.Alpha{A: alpha}), image.Point{}, draw.Over)
+	return nil
+}
+
+// place returns the top left corner of a mark of the given size, keeping a
+// margin from the edges of the image.
+func place(bounds image.Rectangle, size image.Point, position string) image.Point {
+	margin := bounds.Dx() / 50
+	if bounds.Dy() < bounds.Dx() {
+		margin = bounds.Dy() / 50
+	}
+	left, top := bounds.Min.X+margin, bounds.Min.Y+margin
+	right, bottom := bounds.Max.X-margin-size.X, bounds.Max.Y-margin-size.Y
+	switch position {
+	case TopLeft:
+		return image.Pt(left, top)
+	case TopRight:
+		return image.Pt(right, top)
+	case BottomLeft:
+		return image.Pt(left, bottom)
+	case Center:
+		return image.Pt(bounds.Min.X+(bounds.Dx()-size.X)/2, bounds.Min.Y+(bounds.Dy()-size.Y)/2)
+	default:
+		return image.Pt(right, bottom)
+	}
+}
+
+// textMark renders text in white with a dark outline, so it stays readable
+// on light and dark photos alike.
+func textMark(text string, height int) (image.Image, error) {
+	if height < 10 {
+		height = 10
+	}
+	f, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(height), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("watermark font: %v", err)
+	}
+	defer face.Close()
+	outline := height/16 + 1
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil() + 2*outline
+	mark := image.NewRGBA(image.Rect(0, 0, width, (metrics.Ascent+metrics.Descent).Ceil()+2*outline))
+	d := font.Drawer{Dst: mark, Face: face, Src: image.NewUniform(color.RGBA{A: 0xC0})}
+	baseline := metrics.Ascent.Ceil() + outline
+	for dx := -outline; dx <= outline; dx++ {
+		for dy := -outline; dy <= outline; dy++ {
+			d.Dot = fixed.P(outline+dx, baseline+dy)
+			d.DrawString(text)
+		}
+	}
+	d.Src = image.White
+	d.Dot = fixed.P(outline, baseline)
+	d.DrawString(text)
+	return mark, nil
+}
+
+func clamp(value, min, max float64) float64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,104 @@
+// Package downloads signs the links that hand out original images, so an
+// editor can share an original for a limited time without making it public.
+package downloads
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"project/server/config"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("download link is invalid")
+	ErrExpired = errors.New("download link has expired")
+)
+
+var secret = loadSecret()
+
+func loadSecret() []byte {
+	if config.DownloadSecret != "" {
+		return []byte(config.DownloadSecret)
+	}
+	log.Println("DOWNLOAD_SECRET is not set, download links stop working on restart")
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic(err)
+	}
+	return random
+}
+
+// Link gives access to the original image of a post until it expires.
+type Link struct {
+	PostId    int
+	Expires   time.Time
+	Signature string
+}
+
+// New signs a link to the original of a post that works for validFor.
+func New(postId int, validFor time.Duration) Link {
+	return Until(postId, time.Now().Add(validFor))
+}
+
+// Until signs a link to the original of a post that works until expires.
+// Signing it again gives the same link.
+func Until(postId int, expires time.Time) Link {
+	expires = expires.Truncate(time.Second)
+	return Link{PostId: postId, Expires: expires, Signature: sign(secret, postId, expires.Unix())}
+}
+
+func sign(key []byte, postId int, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d:%d", postId, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Path is the path of the link, relative to the site.
+func (l Link) Path() string {
+	query := url.Values{
+		"expires":   {strconv.FormatInt(l.Expires.Unix(), 10)},
+		"signature": {l.Signature},
+	}
+	return fmt.Sprintf("/download/%d?%s", l.PostId, query.Encode())
+}
+
+// URL is the full address of the link on the site the request came in on.
+func (l Link) URL(req *http.Request) string {
+	return SiteURL(req, l.Path())
+}
+
+// SiteURL turns a path into a full address on the site the request came in
+// on, to hand out outside of it.
+func SiteURL(req *http.Request, path string) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + path
+}
+
+// Verify checks the signature and expiry of a link to the original of a
+// post, given the query of its URL.
+func Verify(postId int, query url.Values) (Link, error) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return Link{}, ErrInvalid
+	}
+	link := Link{PostId: postId, Expires: time.Unix(expires, 0), Signature: query.Get("signature")}
+	expected := sign(secret, postId, expires)
+	if !hmac.Equal([]byte(link.Signature), []byte(expected)) {
+		return link, ErrInvalid
+	}
+	if time.Now().After(link.Expires) {
+		return link, ErrExpired
+	}
+	return link, nil
+}
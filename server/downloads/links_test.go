@@ -0,0 +1,50 @@
+package downloads
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	link := New(7, time.Hour)
+	parsed, err := url.Parse(link.Path())
+	if err != nil || parsed.Path != "/download/7" {
+		t.Fatalf("Unexpected link path %q", link.Path())
+	}
+	if _, err := Verify(7, parsed.Query()); err != nil {
+		t.Errorf("A fresh link should verify, got %v", err)
+	}
+	if _, err := Verify(8, parsed.Query()); err != ErrInvalid {
+		t.Errorf("A link should only open its own post, got %v", err)
+	}
+
+	tampered := parsed.Query()
+	tampered.Set("expires", tampered.Get("expires")+"0")
+	if _, err := Verify(7, tampered); err != ErrInvalid {
+		t.Errorf("Extending a link should break its signature, got %v", err)
+	}
+	if _, err := Verify(7, url.Values{}); err != ErrInvalid {
+		t.Errorf("A link without a signature is invalid, got %v", err)
+	}
+
+	expired, _ := url.Parse(New(7, -time.Minute).Path())
+	if _, err := Verify(7, expired.Query()); err != ErrExpired {
+		t.Errorf("An old link should have expired, got %v", err)
+	}
+}
+
+func TestURL(t *testing.T) {
+	link := New(7, time.Hour)
+	req := httptest.NewRequest("GET", "/downloads/7", nil)
+	req.Host = "archief.example"
+	if u := link.URL(req); !strings.HasPrefix(u, "http://archief.example/download/7?") {
+		t.Errorf("Unexpected URL %q", u)
+	}
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if u := link.URL(req); !strings.HasPrefix(u, "https://") {
+		t.Errorf("Links behind a TLS proxy should use https, got %q", u)
+	}
+}
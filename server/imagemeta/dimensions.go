@@ -0,0 +1,54 @@
+package imagemeta
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+const (
+	tagImageWidth  = 0x0100
+	tagImageLength = 0x0101
+	typeShort      = 3
+	typeLong       = 4
+)
+
+// Dimensions reads the size in pixels from the header of a JPEG, PNG, GIF or
+// TIFF file.
+func Dimensions(header []byte) (int, int, error) {
+	if bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")) {
+		return tiffDimensions(header)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(header))
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+func tiffDimensions(tiff []byte) (int, int, error) {
+	order, ifd0, err := tiffHeader(tiff)
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := readIFD(tiff, order, ifd0)
+	if err != nil {
+		return 0, 0, err
+	}
+	var size [2]int
+	for i, tag := range []uint16{tagImageWidth, tagImageLength} {
+		entry, ok := entries[tag]
+		switch {
+		case ok && entry.kind == typeShort:
+			size[i] = int(order.Uint16(entry.value[:]))
+		case ok && entry.kind == typeLong:
+			size[i] = int(order.Uint32(entry.value[:]))
+		default:
+			return 0, 0, errors.New("TIFF has no image size")
+		}
+	}
+	return size[0], size[1], nil
+}
@@ -0,0 +1,170 @@
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	xmpNamespace         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	extendedXMPNamespace = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	exifHeader           = []byte("Exif\x00\x00")
+)
+
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	markerAPPF = 0xEF
+	markerCOM  = 0xFE
+)
+
+// ErrNotEmbedded is wrapped by the errors of EmbedXMP when it copied the
+// image unchanged, because the packet does not fit in a JPEG segment or the
+// header of the JPEG could not be read.
+var ErrNotEmbedded = errors.New("XMP not embedded")
+
+// EmbedXMP copies the JPEG in r to w with packet as its XMP metadata, in
+// place of any XMP the JPEG already had. Only the header segments are held
+// in memory and written once they are read; the image data is streamed.
+func EmbedXMP(w io.Writer, r io.Reader, packet []byte) error {
+	raw := &recorder{r: r, recording: true}
+	if len(xmpNamespace)+len(packet)+2 > 0xFFFF {
+		return copyUnchanged(w, raw, errors.New("XMP packet does not fit in a JPEG segment"))
+	}
+	var head bytes.Buffer
+	src := bufio.NewReader(raw)
+	if err := embedHeader(&head, src, packet); err != nil {
+		return copyUnchanged(w, raw, err)
+	}
+	raw.recording, raw.read = false, nil
+	if _, err := w.Write(head.Bytes()); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, src)
+	return err
+}
+
+// embedHeader writes the header of the JPEG in src to w with packet in place
+// of its XMP, up to where the image data starts.
+func embedHeader(w io.Writer, src *bufio.Reader, packet []byte) error {
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(src, soi); err != nil {
+		return err
+	}
+	if soi[0] != 0xFF || soi[1] != markerSOI {
+		return errors.New("not a JPEG")
+	}
+	if _, err := w.Write(soi); err != nil {
+		return err
+	}
+	embedded := false
+	for {
+		marker, segment, err := readSegment(src)
+		if err != nil {
+			return err
+		}
+		isXMP := marker == markerAPP1 && (bytes.HasPrefix(segment, xmpNamespace) || bytes.HasPrefix(segment, extendedXMPNamespace))
+		if isXMP {
+			continue
+		}
+		// JFIF and EXIF have to come first, the XMP follows them
+		leading := marker == markerAPP0 || (marker == markerAPP1 && bytes.HasPrefix(segment, exifHeader))
+		if !leading && !embedded {
+			if err := writeSegment(w, markerAPP1, append(append([]byte{}, xmpNamespace...), packet...)); err != nil {
+				return err
+			}
+			embedded = true
+		}
+		if segment == nil {
+			// the image data follows the marker without a length
+			_, err := w.Write([]byte{0xFF, marker})
+			return err
+		}
+		if err := writeSegment(w, marker, segment); err != nil {
+			return err
+		}
+		if marker == markerSOS || !(marker >= markerAPP0 && marker <= markerAPPF || marker == markerCOM) {
+			// past the metadata
+			return nil
+		}
+	}
+}
+
+// recorder keeps what is read from r while recording, so an image whose
+// header can not be read can still be copied from its first byte.
+type recorder struct {
+	r         io.Reader
+	read      []byte
+	recording bool
+}
+
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	if rec.recording {
+		rec.read = append(rec.read, p[:n]...)
+	}
+	return n, err
+}
+
+// copyUnchanged writes the image to w as it was read and returns cause
+// wrapped in ErrNotEmbedded.
+func copyUnchanged(w io.Writer, rec *recorder, cause error) error {
+	if _, err := w.Write(rec.read); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, rec.r); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrNotEmbedded, cause)
+}
+
+// readSegment reads the next marker and the payload of its segment. Markers
+// without a payload return a nil segment.
+func readSegment(src *bufio.Reader) (byte, []byte, error) {
+	b, err := src.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if b != 0xFF {
+		return 0, nil, fmt.Errorf("expected a JPEG marker, got %#x", b)
+	}
+	marker := byte(0xFF)
+	// markers may be padded with fill bytes
+	for marker == 0xFF {
+		if marker, err = src.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if marker >= 0xD0 && marker <= 0xD9 || marker == 0x01 {
+		return marker, nil, nil
+	}
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(src, length); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(length))
+	if n < 2 {
+		return 0, nil, errors.New("malformed JPEG segment length")
+	}
+	segment := make([]byte, n-2)
+	if _, err := io.ReadFull(src, segment); err != nil {
+		return 0, nil, err
+	}
+	return marker, segment, nil
+}
+
+func writeSegment(w io.Writer, marker byte, segment []byte) error {
+	header := []byte{0xFF, marker}
+	header = binary.BigEndian.AppendUint16(header, uint16(len(segment)+2))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(segment)
+	return err
+}
@@ -0,0 +1,88 @@
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// xmpSegments returns the XMP packets in the header of a JPEG.
+func xmpSegments(t *testing.T, data []byte) [][]byte {
+	src := bufio.NewReader(bytes.NewReader(data[2:]))
+	var packets [][]byte
+	for {
+		marker, segment, err := readSegment(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if marker == markerSOS {
+			return packets
+		}
+		if marker == markerAPP1 && bytes.HasPrefix(segment, xmpNamespace) {
+			packets = append(packets, segment[len(xmpNamespace):])
+		}
+	}
+}
+
+func TestEmbedXMP(t *testing.T) {
+	var original bytes.Buffer
+	jpeg.Encode(&original, image.NewGray(image.Rect(0, 0, 16, 8)), nil)
+	var first, second bytes.Buffer
+	if err := EmbedXMP(&first, bytes.NewReader(original.Bytes()), []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedXMP(&second, bytes.NewReader(first.Bytes()), []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	packets := xmpSegments(t, second.Bytes())
+	if len(packets) != 1 || string(packets[0]) != "second" {
+		t.Errorf("Expected the XMP to be replaced, got %q", packets)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(second.Bytes()))
+	if err != nil || img.Bounds().Dx() != 16 || img.Bounds().Dy() != 8 {
+		t.Errorf("The image should still decode, got %v", err)
+	}
+
+	tiff := exifTIFF('N', [3]uint32{51, 33, 20}, 'E', [3]uint32{5, 5, 28})
+	var located bytes.Buffer
+	if err := EmbedXMP(&located, bytes.NewReader(exifJPEG(tiff)), []byte("packet")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadLocation(located.Bytes()); err != nil {
+		t.Errorf("The Exif should stay in front of the XMP: %v", err)
+	}
+	var gif bytes.Buffer
+	if err := EmbedXMP(&gif, bytes.NewReader([]byte("GIF89a")), nil); !errors.Is(err, ErrNotEmbedded) {
+		t.Errorf("Only JPEGs can get XMP embedded, got %v", err)
+	}
+	if gif.String() != "GIF89a" {
+		t.Errorf("Other images should be copied unchanged, got %q", gif.String())
+	}
+}
+
+func TestEmbedXMPFallback(t *testing.T) {
+	var original bytes.Buffer
+	jpeg.Encode(&original, image.NewGray(image.Rect(0, 0, 16, 8)), nil)
+	malformed := append([]byte{0xFF, markerSOI, 0xFF, markerAPP1, 0x00}, bytes.Repeat([]byte{0xAB}, 10000)...)
+	tests := []struct {
+		Description string
+		Image       []byte
+		Packet      []byte
+	}{
+		{"packet too large", original.Bytes(), bytes.Repeat([]byte("x"), 0x10000)},
+		{"malformed header", malformed, []byte("packet")},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := EmbedXMP(&out, bytes.NewReader(test.Image), test.Packet)
+		if !errors.Is(err, ErrNotEmbedded) {
+			t.Errorf("Test '%s' failed because ErrNotEmbedded was expected, got %v", test.Description, err)
+		}
+		if !bytes.Equal(out.Bytes(), test.Image) {
+			t.Errorf("Test '%s' failed because the image was not copied unchanged.", test.Description)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// HeaderSize is how much of the start of a file ReadLocation and
+// ReadOrientation need; an EXIF segment in a JPEG is at most 64 KiB.
+const HeaderSize = 128 << 10
+
+var ErrNoLocation = errors.New("image has no GPS location")
+
+// Location is a position in decimal degrees.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+const (
+	tagGPSIFD       = 0x8825
+	tagLatitudeRef  = 0x0001
+	tagLatitude     = 0x0002
+	tagLongitudeRef = 0x0003
+	tagLongitude    = 0x0004
+	tagOrientation  = 0x0112
+	typeASCII       = 2
+	typeRational    = 5
+)
+
+// ReadLocation reads the GPS position from the EXIF data in the header of a
+// JPEG or TIFF file.
+func ReadLocation(header []byte) (Location, error) {
+	tiff, err := findTIFF(header)
+	if err != nil {
+		return Location{}, err
+	}
+	order, ifd0, err := tiffHeader(tiff)
+	if err != nil {
+		return Location{}, err
+	}
+	entries, err := readIFD(tiff, order, ifd0)
+	if err != nil {
+		return Location{}, err
+	}
+	gpsEntry, ok := entries[tagGPSIFD]
+	if !ok {
+		return Location{}, ErrNoLocation
+	}
+	gps, err := readIFD(tiff, order, order.Uint32(gpsEntry.value[:]))
+	if err != nil {
+		return Location{}, err
+	}
+	latitude, err := degrees(tiff, order, gps, tagLatitude, tagLatitudeRef, 'S')
+	if err != nil {
+		return Location{}, err
+	}
+	longitude, err := degrees(tiff, order, gps, tagLongitude, tagLongitudeRef, 'W')
+	if err != nil {
+		return Location{}, err
+	}
+	if math.Abs(latitude) > 90 || math.Abs(longitude) > 180 {
+		return Location{}, errors.New("GPS location out of range")
+	}
+	return Location{Latitude: latitude, Longitude: longitude}, nil
+}
+
+// ReadOrientation reads how the camera was held from the EXIF data in the
+// header of a JPEG or TIFF file. It returns one of the eight EXIF
+// orientations, 1 for upright images and when there is no orientation.
+func ReadOrientation(header []byte) int {
+	tiff, err := findTIFF(header)
+	if err != nil {
+		return 1
+	}
+	order, ifd0, err := tiffHeader(tiff)
+	if err != nil {
+		return 1
+	}
+	entries, err := readIFD(tiff, order, ifd0)
+	if err != nil {
+		return 1
+	}
+	entry, ok := entries[tagOrientation]
+	if !ok || entry.kind != typeShort {
+		return 1
+	}
+	orientation := int(order.Uint16(entry.value[:]))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// findTIFF returns the TIFF structure holding the EXIF data: the file itself
+// for a TIFF, or the APP1 segment of a JPEG.
+func findTIFF(header []byte) ([]byte, error) {
+	if bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")) {
+		return header, nil
+	}
+	if !bytes.HasPrefix(header, []byte{0xFF, 0xD8}) {
+		return nil, ErrNoLocation
+	}
+	for i := 2; i+4 <= len(header); {
+		if header[i] != 0xFF {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		marker := header[i+1]
+		// start of scan: the image data follows, there is no EXIF
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(header[i+2:]))
+		if length < 2 || i+2+length > len(header) {
+			break
+		}
+		segment := header[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+		i += 2 + length
+	}
+	return nil, ErrNoLocation
+}
+
+func tiffHeader(tiff []byte) (binary.ByteOrder, uint32, error) {
+	if len(tiff) < 8 {
+		return nil, 0, errors.New("truncated TIFF header")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("unknown TIFF byte order")
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return nil, 0, errors.New("not a TIFF header")
+	}
+	return order, order.Uint32(tiff[4:]), nil
+}
+
+type ifdEntry struct {
+	kind  uint16
+	count uint32
+	value [4]byte
+}
+
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16]ifdEntry, error) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, errors.New("IFD outside of the header")
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+12*n > len(tiff) {
+		return nil, errors.New("IFD outside of the header")
+	}
+	entries := make(map[uint16]ifdEntry, n)
+	for i := 0; i < n; i++ {
+		raw := tiff[start+12*i:]
+		entry := ifdEntry{kind: order.Uint16(raw[2:]), count: order.Uint32(raw[4:])}
+		copy(entry.value[:], raw[8:12])
+		entries[order.Uint16(raw)] = entry
+	}
+	return entries, nil
+}
+
+// degrees converts a degrees, minutes, seconds GPS coordinate to decimal
+// degrees, negative when the reference is negativeRef.
+func degrees(tiff []byte, order binary.ByteOrder, gps map[uint16]ifdEntry, tag, refTag uint16, negativeRef byte) (float64, error) {
+	entry, ok := gps[tag]
+	if !ok || entry.kind != typeRational || entry.count != 3 {
+		return 0, ErrNoLocation
+	}
+	offset := uint64(order.Uint32(entry.value[:]))
+	if offset+24 > uint64(len(tiff)) {
+		return 0, errors.New("GPS coordinate outside of the header")
+	}
+	var parts [3]float64
+	for i := range parts {
+		numerator := order.Uint32(tiff[offset+uint64(8*i):])
+		denominator := order.Uint32(tiff[offset+uint64(8*i)+4:])
+		if denominator == 0 {
+			return 0, errors.New("GPS coordinate divides by zero")
+		}
+		parts[i] = float64(numerator) / float64(denominator)
+	}
+	value := parts[0] + parts[1]/60 + parts[2]/3600
+	if ref, ok := gps[refTag]; ok && ref.kind == typeASCII && ref.value[0] == negativeRef {
+		value = -value
+	}
+	return value, nil
+}
@@ -0,0 +1,113 @@
+package imagemeta
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// exifTIFF builds a big-endian TIFF structure with only a GPS IFD holding the
+// given degrees, minutes and seconds.
+func exifTIFF(latRef byte, lat [3]uint32, lonRef byte, lon [3]uint32) []byte {
+	be := binary.BigEndian
+	tiff := []byte("MM\x00*")
+	tiff = be.AppendUint32(tiff, 8)
+	// IFD0 with a single pointer to the GPS IFD at offset 26
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = be.AppendUint16(tiff, tagGPSIFD)
+	tiff = be.AppendUint16(tiff, 4)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint32(tiff, 26)
+	tiff = be.AppendUint32(tiff, 0)
+	// GPS IFD with the rationals following it at offsets 80 and 104
+	tiff = be.AppendUint16(tiff, 4)
+	entries := []struct {
+		tag, kind uint16
+		value     []byte
+	}{
+		{tagLatitudeRef, typeASCII, []byte{latRef, 0, 0, 0}},
+		{tagLatitude, typeRational, be.AppendUint32(nil, 80)},
+		{tagLongitudeRef, typeASCII, []byte{lonRef, 0, 0, 0}},
+		{tagLongitude, typeRational, be.AppendUint32(nil, 104)},
+	}
+	for _, entry := range entries {
+		count := uint32(2)
+		if entry.kind == typeRational {
+			count = 3
+		}
+		tiff = be.AppendUint16(tiff, entry.tag)
+		tiff = be.AppendUint16(tiff, entry.kind)
+		tiff = be.AppendUint32(tiff, count)
+		tiff = append(tiff, entry.value...)
+	}
+	tiff = be.AppendUint32(tiff, 0)
+	for _, n := range append(lat[:], lon[:]...) {
+		tiff = be.AppendUint32(tiff, n)
+		tiff = be.AppendUint32(tiff, 1)
+	}
+	return tiff
+}
+
+func exifJPEG(tiff []byte) []byte {
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg = append(jpeg, 0xFF, 0xE1)
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	return append(jpeg, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func TestReadLocation(t *testing.T) {
+	tilburg := exifTIFF('N', [3]uint32{51, 33, 20}, 'E', [3]uint32{5, 5, 28})
+	tests := []struct {
+		name      string
+		header    []byte
+		latitude  float64
+		longitude float64
+		err       error
+	}{
+		{"jpeg", exifJPEG(tilburg), 51.555556, 5.091111, nil},
+		{"tiff", tilburg, 51.555556, 5.091111, nil},
+		{"south west", exifTIFF('S', [3]uint32{33, 52, 0}, 'W', [3]uint32{70, 40, 0}), -33.866667, -70.666667, nil},
+		{"no exif", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, 0, 0, ErrNoLocation},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), 0, 0, ErrNoLocation},
+	}
+	for _, test := range tests {
+		location, err := ReadLocation(test.header)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+			continue
+		}
+		if math.Abs(location.Latitude-test.latitude) > 1e-6 || math.Abs(location.Longitude-test.longitude) > 1e-6 {
+			t.Errorf("%s: expected %f,%f, got %f,%f", test.name, test.latitude, test.longitude, location.Latitude, location.Longitude)
+		}
+	}
+}
+
+func TestReadLocationTruncated(t *testing.T) {
+	jpeg := exifJPEG(exifTIFF('N', [3]uint32{51, 33, 20}, 'E', [3]uint32{5, 5, 28}))
+	// the last four bytes start the image data after the EXIF segment
+	for n := 0; n < len(jpeg)-4; n++ {
+		if _, err := ReadLocation(jpeg[:n]); err == nil {
+			t.Errorf("Reading %d of %d bytes should fail.", n, len(jpeg))
+		}
+	}
+}
+
+func TestReadOrientation(t *testing.T) {
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = append(tiff, 0x12, 0x01, typeShort, 0, 1, 0, 0, 0, 6, 0, 0, 0)
+	if orientation := ReadOrientation(exifJPEG(tiff)); orientation != 6 {
+		t.Errorf("Expected orientation 6, got %d", orientation)
+	}
+	gps := exifTIFF('N', [3]uint32{51, 33, 20}, 'E', [3]uint32{5, 5, 28})
+	if orientation := ReadOrientation(exifJPEG(gps)); orientation != 1 {
+		t.Errorf("Images without an orientation are upright, got %d", orientation)
+	}
+	if orientation := ReadOrientation(nil); orientation != 1 {
+		t.Errorf("Unreadable images are upright, got %d", orientation)
+	}
+}
@@ -0,0 +1,158 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+)
+
+// XMP is the descriptive metadata of a photo, written as an XMP packet for
+// sidecar files and for embedding in images.
+type XMP struct {
+	Title       string
+	Description string
+	Keywords    []string
+	// Width and Height are the size in pixels the regions were drawn on,
+	// zero when unknown.
+	Width   int
+	Height  int
+	Regions []Region
+	// Source identifies the original the image was digitized from, such as
+	// a negative.
+	Source string
+	// CreatorTool is the scanner that digitized the image.
+	CreatorTool string
+	// Digitized is the scan date as 2006-01-02 and Resolution the scan
+	// resolution in dots per inch.
+	Digitized  string
+	Resolution int
+	// Copyright is the copyright notice and UsageTerms how the image may be
+	// used. Licensed images link to their license in WebStatement. Marked
+	// tells whether the image is protected by copyright at all.
+	Copyright    string
+	UsageTerms   string
+	WebStatement string
+	Marked       bool
+	Credit       string
+}
+
+// Region is an area of a photo in the Metadata Working Group convention: X
+// and Y are its centre and Width and Height its size, all as fractions of
+// the image.
+type Region struct {
+	Name   string
+	Type   string
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+const (
+	xmpHeader = "<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" +
+		"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n" +
+		" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n" +
+		"  <rdf:Description rdf:about=\"\"\n" +
+		"    xmlns:dc=\"http://purl.org/dc/elements/1.1/\"\n" +
+		"    xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\"\n" +
+		"    xmlns:exif=\"http://ns.adobe.com/exif/1.0/\"\n" +
+		"    xmlns:tiff=\"http://ns.adobe.com/tiff/1.0/\"\n" +
+		"    xmlns:xmpRights=\"http://ns.adobe.com/xap/1.0/rights/\"\n" +
+		"    xmlns:photoshop=\"http://ns.adobe.com/photoshop/1.0/\"\n" +
+		"    xmlns:cc=\"http://creativecommons.org/ns#\"\n" +
+		"    xmlns:mwg-rs=\"http://www.metadataworkinggroup.com/schemas/regions/\"\n" +
+		"    xmlns:stDim=\"http://ns.adobe.com/xap/1.0/sType/Dimensions#\"\n" +
+		"    xmlns:stArea=\"http://ns.adobe.com/xmp/sType/Area#\">\n"
+	xmpFooter = "  </rdf:Description>\n" +
+		" </rdf:RDF>\n" +
+		"</x:xmpmeta>\n" +
+		"<?xpacket end=\"w\"?>"
+)
+
+// Marshal writes the metadata as an XMP packet, leaving out empty fields.
+func (x XMP) Marshal() []byte {
+	var b bytes.Buffer
+	b.WriteString(xmpHeader)
+	langAlt(&b, "dc:title", x.Title)
+	langAlt(&b, "dc:description", x.Description)
+	if len(x.Keywords) > 0 {
+		b.WriteString("   <dc:subject><rdf:Bag>")
+		for _, keyword := range x.Keywords {
+			b.WriteString("<rdf:li>")
+			xml.EscapeText(&b, []byte(keyword))
+			b.WriteString("</rdf:li>")
+		}
+		b.WriteString("</rdf:Bag></dc:subject>\n")
+	}
+	simple(&b, "dc:source", x.Source)
+	simple(&b, "xmp:CreatorTool", x.CreatorTool)
+	simple(&b, "exif:DateTimeDigitized", x.Digitized)
+	if x.Resolution > 0 {
+		resolution := strconv.Itoa(x.Resolution) + "/1"
+		simple(&b, "tiff:XResolution", resolution)
+		simple(&b, "tiff:YResolution", resolution)
+		// inches
+		simple(&b, "tiff:ResolutionUnit", "2")
+	}
+	langAlt(&b, "dc:rights", x.Copyright)
+	langAlt(&b, "xmpRights:UsageTerms", x.UsageTerms)
+	if x.Copyright != "" || x.WebStatement != "" {
+		// XMP spells booleans with a capital
+		marked := "False"
+		if x.Marked {
+			marked = "True"
+		}
+		simple(&b, "xmpRights:Marked", marked)
+	}
+	simple(&b, "xmpRights:WebStatement", x.WebStatement)
+	if x.WebStatement != "" {
+		b.WriteString("   <cc:license rdf:resource=\"")
+		xml.EscapeText(&b, []byte(x.WebStatement))
+		b.WriteString("\"/>\n")
+	}
+	simple(&b, "photoshop:Credit", x.Credit)
+	if len(x.Regions) > 0 {
+		b.WriteString("   <mwg-rs:Regions rdf:parseType=\"Resource\">\n")
+		if x.Width > 0 && x.Height > 0 {
+			b.WriteString("    <mwg-rs:AppliedToDimensions stDim:w=\"" + strconv.Itoa(x.Width) + "\" stDim:h=\"" + strconv.Itoa(x.Height) + "\" stDim:unit=\"pixel\"/>\n")
+		}
+		b.WriteString("    <mwg-rs:RegionList><rdf:Bag>\n")
+		for _, region := range x.Regions {
+			b.WriteString("     <rdf:li rdf:parseType=\"Resource\"><mwg-rs:Name>")
+			xml.EscapeText(&b, []byte(region.Name))
+			b.WriteString("</mwg-rs:Name><mwg-rs:Type>")
+			xml.EscapeText(&b, []byte(region.Type))
+			b.WriteString("</mwg-rs:Type><mwg-rs:Area stArea:x=\"" + fraction(region.X) + "\" stArea:y=\"" + fraction(region.Y) +
+				"\" stArea:w=\"" + fraction(region.Width) + "\" stArea:h=\"" + fraction(region.Height) + "\" stArea:unit=\"normalized\"/></rdf:li>\n")
+		}
+		b.WriteString("    </rdf:Bag></mwg-rs:RegionList>\n")
+		b.WriteString("   </mwg-rs:Regions>\n")
+	}
+	b.WriteString(xmpFooter)
+	return b.Bytes()
+}
+
+// langAlt writes a property that XMP allows in several languages, in the
+// default language only.
+func langAlt(b *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString("   <" + name + "><rdf:Alt><rdf:li xml:lang=\"x-default\">")
+	xml.EscapeText(b, []byte(value))
+	b.WriteString("</rdf:li></rdf:Alt></" + name + ">\n")
+}
+
+// simple writes a property with a text value.
+func simple(b *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString("   <" + name + ">")
+	xml.EscapeText(b, []byte(value))
+	b.WriteString("</" + name + ">\n")
+}
+
+func fraction(f float64) string {
+	return strconv.FormatFloat(f, 'f', 6, 64)
+}
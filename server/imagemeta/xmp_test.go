@@ -0,0 +1,71 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMarshalXMP(t *testing.T) {
+	x := XMP{
+		Title:      "Kermis <1965>",
+		Keywords:   []string{"kermis", "Piet & Jan"},
+		Width:      3000,
+		Height:     2000,
+		Regions:    []Region{{Name: "Jan", Type: "Face", X: 0.25, Y: 0.5, Width: 0.1, Height: 0.2}},
+		Source:     "box 3, roll R12, negative 7",
+		Digitized:  "2023-04-01",
+		Resolution: 3200,
+	}
+	packet := x.Marshal()
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("The packet should be well-formed XML: %v\n%s", err, packet)
+		}
+	}
+	for _, expected := range []string{
+		"Kermis &lt;1965&gt;",
+		"<rdf:li>Piet &amp; Jan</rdf:li>",
+		`stDim:w="3000" stDim:h="2000"`,
+		`stArea:x="0.250000" stArea:y="0.500000" stArea:w="0.100000" stArea:h="0.200000"`,
+		"<dc:source>box 3, roll R12, negative 7</dc:source>",
+		"<exif:DateTimeDigitized>2023-04-01</exif:DateTimeDigitized>",
+		"<tiff:XResolution>3200/1</tiff:XResolution>",
+	} {
+		if !bytes.Contains(packet, []byte(expected)) {
+			t.Errorf("The packet should contain %s:\n%s", expected, packet)
+		}
+	}
+	if strings.Contains(string(XMP{}.Marshal()), "dc:title") {
+		t.Error("Empty fields should be left out.")
+	}
+}
+
+func TestDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 30, 20)))
+	width, height, err := Dimensions(buf.Bytes())
+	if err != nil || width != 30 || height != 20 {
+		t.Errorf("Expected a 30x20 PNG, got %dx%d %v", width, height, err)
+	}
+
+	tiff := []byte("II*\x00")
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x00, 0x01, typeShort, 0, 1, 0, 0, 0, 0x2C, 0x01, 0, 0)
+	tiff = append(tiff, 0x01, 0x01, typeLong, 0, 1, 0, 0, 0, 0xC8, 0x00, 0, 0)
+	width, height, err = Dimensions(tiff)
+	if err != nil || width != 300 || height != 200 {
+		t.Errorf("Expected a 300x200 TIFF, got %dx%d %v", width, height, err)
+	}
+}
@@ -0,0 +1,254 @@
+package licensing
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"project/server/config"
+	"project/server/downloads"
+	"project/server/posts"
+	"project/server/users"
+	"strconv"
+)
+
+// RequestHandler shows visitors the form to request the use of the photos
+// selected with ?post=, and records the request on POST.
+func RequestHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Posts    []posts.Post
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	if req.Method == http.MethodPost {
+		req.ParseForm()
+		postIds, err := parseIds(req.PostForm["post"])
+		if err != nil {
+			http.Error(w, "Malformatted post id", http.StatusForbidden)
+			return
+		}
+		r := Request{
+			Name:         req.PostFormValue("name"),
+			Email:        req.PostFormValue("email"),
+			Organisation: req.PostFormValue("organisation"),
+			IntendedUse:  req.PostFormValue("intended_use"),
+			PostIds:      postIds,
+		}
+		if _, err := r.validate(); err != nil {
+			http.Error(w, "Malformatted request: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		_, token, err := CreateRequest(r)
+		if err == errNoPosts {
+			http.Error(w, "Select at least one photo to request.", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error recording request. Please try again or contact administrator.", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/request/"+token, http.StatusSeeOther)
+		return
+	}
+	postIds, err := parseIds(req.URL.Query()["post"])
+	if err != nil {
+		http.Error(w, "Malformatted post id", http.StatusForbidden)
+		return
+	}
+	postSlice, err := posts.ListPostsById(postIds, false)
+	if err != nil {
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	if len(postSlice) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+	d := data{
+		Posts:    postSlice,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "request.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// RequestStatusHandler shows requesters how their request is doing and, once
+// it is delivered, the links to download the originals. Posts that are no
+// longer public are left out.
+func RequestStatusHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Request  Request
+		Posts    []posts.Post
+		Links    map[int]string
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	r, err := GetRequestByToken(req.URL.Path[len("/request/"):])
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error retrieving request from the database", http.StatusInternalServerError)
+		return
+	}
+	postSlice, err := posts.ListPostsById(r.PostIds, false)
+	if err != nil {
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Request:  r,
+		Posts:    postSlice,
+		Links:    linkPaths(r.Links()),
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "requeststatus.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// InboxHandler lists the requests for editors, optionally only those with
+// the ?status= given.
+func InboxHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Requests []Request
+		Status   string
+		Statuses []string
+		Counts   map[string]int
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	status := req.URL.Query().Get("status")
+	if status != "" && !validStatus(status) {
+		http.Error(w, "Unknown status", http.StatusForbidden)
+		return
+	}
+	requests, err := ListRequests(status)
+	if err != nil {
+		http.Error(w, "error retrieving requests from the database", http.StatusInternalServerError)
+		return
+	}
+	counts, err := CountRequests()
+	if err != nil {
+		http.Error(w, "error retrieving requests from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Requests: requests,
+		Status:   status,
+		Statuses: Statuses,
+		Counts:   counts,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "requests.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// InboxRequestHandler shows a request to editors and lets them quote,
+// approve, deliver or delete it.
+func InboxRequestHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Request   Request
+		Posts     []posts.Post
+		Statuses  []string
+		StatusURL string
+		Links     map[int]string
+		LoggedIn  bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	requestId, err := strconv.Atoi(req.URL.Path[len("/requests/"):])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method == http.MethodPost {
+		switch req.PostFormValue("action") {
+		case "save":
+			err = UpdateRequest(requestId, req.PostFormValue("status"), req.PostFormValue("quote"), req.PostFormValue("notes"))
+		case "delete":
+			err = DeleteRequest(requestId)
+			if err == nil {
+				http.Redirect(w, req, "/requests", http.StatusSeeOther)
+				return
+			}
+		default:
+			http.Error(w, "Unknown action", http.StatusForbidden)
+			return
+		}
+		if err == sql.ErrNoRows {
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error updating request. Please try again or contact administrator.", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/requests/"+strconv.Itoa(requestId), http.StatusSeeOther)
+		return
+	}
+	r, err := GetRequest(requestId)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error retrieving request from the database", http.StatusInternalServerError)
+		return
+	}
+	postSlice, err := posts.ListPostsById(r.PostIds, true)
+	if err != nil {
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	links := make(map[int]string)
+	for _, link := range r.Links() {
+		links[link.PostId] = link.URL(req)
+	}
+	d := data{
+		Request:   r,
+		Posts:     postSlice,
+		Statuses:  Statuses,
+		StatusURL: downloads.SiteURL(req, "/request/"+r.Token),
+		Links:     links,
+		LoggedIn:  loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "requestinbox.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+func linkPaths(links []downloads.Link) map[int]string {
+	paths := make(map[int]string)
+	for _, link := range links {
+		paths[link.PostId] = link.Path()
+	}
+	return paths
+}
+
+func parseIds(values []string) ([]int, error) {
+	ids := make([]int, 0)
+	for _, value := range values {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
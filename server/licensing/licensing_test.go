@@ -0,0 +1,98 @@
+package licensing
+
+import (
+	"net/url"
+	"project/server/config"
+	"project/server/downloads"
+	"project/server/posts"
+	"project/server/users"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRequests(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE license_requests RESTART IDENTITY CASCADE;")
+	for _, year := range []int{1962, 1963} {
+		posts.CreatePost("image.jpg", year, 1)
+	}
+	draftId, _ := posts.CreateDraft("draft.jpg", posts.FuzzyDate{}, 1)
+
+	r := Request{Name: " Krant ", Email: "redactie@krant.example", IntendedUse: "Artikel over de kermis", PostIds: []int{2, 1, *draftId}}
+	invalid := []Request{
+		{Email: r.Email, IntendedUse: r.IntendedUse, PostIds: r.PostIds},
+		{Name: r.Name, Email: "redactie", IntendedUse: r.IntendedUse, PostIds: r.PostIds},
+		{Name: r.Name, Email: r.Email, PostIds: r.PostIds},
+	}
+	for _, request := range invalid {
+		if _, _, err := CreateRequest(request); err == nil {
+			t.Errorf("Incomplete request %+v should be rejected.", request)
+		}
+	}
+	if _, _, err := CreateRequest(Request{Name: r.Name, Email: r.Email, IntendedUse: r.IntendedUse, PostIds: []int{*draftId}}); err != errNoPosts {
+		t.Errorf("Drafts should not be requested, got %v", err)
+	}
+
+	requestId, token, err := CreateRequest(r)
+	if err != nil {
+		t.Fatalf("Request could not be created: %v", err)
+	}
+	r, err = GetRequestByToken(token)
+	if err != nil || r.Id != requestId || r.Name != "Krant" || r.Status != StatusNew {
+		t.Fatalf("The request should be found by its token: %+v %v", r, err)
+	}
+	if !reflect.DeepEqual(r.PostIds, []int{1, 2}) {
+		t.Errorf("Only the public posts should be requested, got %v.", r.PostIds)
+	}
+	if len(r.Links()) != 0 {
+		t.Error("A new request should not have download links.")
+	}
+
+	if err := UpdateRequest(requestId, "paid", "", ""); err == nil {
+		t.Error("Unknown statuses should be rejected.")
+	}
+	if err := UpdateRequest(requestId, StatusQuoted, " 50 euro per foto ", "belde op dinsdag"); err != nil {
+		t.Fatal(err)
+	}
+	quoted, _ := ListRequests(StatusQuoted)
+	if len(quoted) != 1 || quoted[0].Quote != "50 euro per foto" {
+		t.Errorf("The inbox should list the quoted request, got %+v.", quoted)
+	}
+	if others, _ := ListRequests(StatusNew); len(others) != 0 {
+		t.Errorf("The quoted request should no longer be new, got %d.", len(others))
+	}
+
+	if err := UpdateRequest(requestId, StatusDelivered, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	r, _ = GetRequest(requestId)
+	links := r.Links()
+	if !r.DeliveredAt.Valid || len(links) != 2 {
+		t.Fatalf("A delivered request should have a link per photo, got %d.", len(links))
+	}
+	for _, link := range links {
+		parsed, _ := url.Parse(link.Path())
+		if _, err := downloads.Verify(link.PostId, parsed.Query()); err != nil {
+			t.Errorf("The download link of post %d should work: %v", link.PostId, err)
+		}
+	}
+	time.Sleep(time.Second)
+	UpdateRequest(requestId, StatusDelivered, "", "")
+	r, _ = GetRequest(requestId)
+	if !reflect.DeepEqual(r.Links(), links) {
+		t.Error("Saving a delivered request again should keep its links.")
+	}
+	counts, _ := CountRequests()
+	if counts[StatusDelivered] != 1 {
+		t.Errorf("Expected one delivered request, got %v.", counts)
+	}
+
+	if err := DeleteRequest(requestId); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateRequest(requestId, StatusNew, "", ""); err == nil {
+		t.Error("A deleted request cannot be updated.")
+	}
+}
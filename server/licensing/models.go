@@ -0,0 +1,240 @@
+package licensing
+
+import (
+	"database/sql"
+	"errors"
+	"project/server/config"
+	"project/server/downloads"
+	"project/server/posts"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+// The statuses a request moves through, from asked for to handed over.
+const (
+	StatusNew       = "new"
+	StatusQuoted    = "quoted"
+	StatusApproved  = "approved"
+	StatusDelivered = "delivered"
+)
+
+var Statuses = []string{StatusNew, StatusQuoted, StatusApproved, StatusDelivered}
+
+// statusLabels name the statuses on the status page of the requester.
+var statusLabels = map[string]string{
+	StatusNew:       "ontvangen",
+	StatusQuoted:    "offerte verstuurd",
+	StatusApproved:  "goedgekeurd",
+	StatusDelivered: "geleverd",
+}
+
+func validStatus(status string) bool {
+	_, ok := statusLabels[status]
+	return ok
+}
+
+// Request is someone asking to use photos of the archive. The requester
+// follows it on a status page only they know the token of.
+type Request struct {
+	Id           int
+	Token        string
+	Name         string
+	Email        string
+	Organisation string
+	IntendedUse  string
+	Status       string
+	// Quote is the price and conditions offered to the requester.
+	Quote string
+	// Notes are for the archive owner only.
+	Notes       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeliveredAt sql.NullTime
+	PostIds     []int
+}
+
+var errNoPosts = errors.New("a request needs at least one public photo")
+
+// StatusLabel is the status as the requester reads it.
+func (r Request) StatusLabel() string {
+	return statusLabels[r.Status]
+}
+
+// Expires is when the download links of a delivered request stop working.
+func (r Request) Expires() time.Time {
+	return r.DeliveredAt.Time.Add(config.DownloadLinkValidity)
+}
+
+// Links are the downloads of the originals once the request is delivered.
+// They stay the same for as long as they work.
+func (r Request) Links() []downloads.Link {
+	links := make([]downloads.Link, 0)
+	if r.Status != StatusDelivered || !r.DeliveredAt.Valid {
+		return links
+	}
+	for _, postId := range r.PostIds {
+		links = append(links, downloads.Until(postId, r.Expires()))
+	}
+	return links
+}
+
+// validate trims the details of a new request and checks they are complete.
+func (r Request) validate() (Request, error) {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Email = strings.TrimSpace(r.Email)
+	r.Organisation = strings.TrimSpace(r.Organisation)
+	r.IntendedUse = strings.TrimSpace(r.IntendedUse)
+	switch {
+	case r.Name == "":
+		return r, errors.New("a request needs the name of the requester")
+	case !strings.Contains(r.Email, "@"):
+		return r, errors.New("a request needs an email address to reply to")
+	case r.IntendedUse == "":
+		return r, errors.New("a request needs the intended use of the photos")
+	}
+	return r, nil
+}
+
+// CreateRequest records a request for the public posts among its post ids
+// and returns its id and token.
+func CreateRequest(r Request) (int, string, error) {
+	r, err := r.validate()
+	if err != nil {
+		return 0, "", err
+	}
+	postSlice, err := posts.ListPostsById(r.PostIds, false)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(postSlice) == 0 {
+		return 0, "", errNoPosts
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+	token := uuid.NewV4().String()
+	var requestId int
+	err = tx.QueryRow(`
+		INSERT INTO license_requests (TOKEN, NAME, EMAIL, ORGANISATION, INTENDED_USE)
+		VALUES ($1, $2, $3, $4, $5) RETURNING ID;`,
+		token, r.Name, r.Email, r.Organisation, r.IntendedUse).Scan(&requestId)
+	if err != nil {
+		return 0, "", err
+	}
+	for _, post := range postSlice {
+		_, err = tx.Exec("INSERT INTO license_request_posts (REQUEST_ID, POST_ID) VALUES ($1, $2) ON CONFLICT DO NOTHING;", requestId, post.Id)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+	return requestId, token, tx.Commit()
+}
+
+// requestColumns lists the request columns in the order scanRequest expects
+// them, followed by the requested posts.
+const requestColumns = `r.id, r.token, r.name, r.email, r.organisation, r.intended_use, r.status, r.quote, r.notes,
+	r.created_at, r.updated_at, r.delivered_at,
+	ARRAY(SELECT rp.post_id FROM license_request_posts rp WHERE rp.request_id = r.id ORDER BY rp.post_id)`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRequest(row scanner) (Request, error) {
+	r := Request{}
+	var postIds pq.Int64Array
+	err := row.Scan(&r.Id, &r.Token, &r.Name, &r.Email, &r.Organisation, &r.IntendedUse, &r.Status, &r.Quote, &r.Notes,
+		&r.CreatedAt, &r.UpdatedAt, &r.DeliveredAt, &postIds)
+	r.PostIds = make([]int, len(postIds))
+	for i, id := range postIds {
+		r.PostIds[i] = int(id)
+	}
+	return r, err
+}
+
+func GetRequest(requestId int) (Request, error) {
+	row := config.DB.QueryRow("SELECT "+requestColumns+" FROM license_requests r WHERE r.id = $1;", requestId)
+	return scanRequest(row)
+}
+
+// GetRequestByToken returns the request the requester follows on their
+// status page.
+func GetRequestByToken(token string) (Request, error) {
+	row := config.DB.QueryRow("SELECT "+requestColumns+" FROM license_requests r WHERE r.token = $1;", token)
+	return scanRequest(row)
+}
+
+// ListRequests returns the requests with the given status, or all of them
+// when status is empty, newest first.
+func ListRequests(status string) ([]Request, error) {
+	requests := make([]Request, 0)
+	rows, err := config.DB.Query(`
+		SELECT `+requestColumns+`
+		FROM license_requests r
+		WHERE $1 = '' OR r.status = $1
+		ORDER BY r.created_at DESC, r.id DESC;
+		`, status)
+	if err != nil {
+		return requests, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			return requests, err
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+// UpdateRequest moves a request to a status with the quote and notes of the
+// owner. Delivering it starts the download links.
+func UpdateRequest(requestId int, status, quote, notes string) error {
+	if !validStatus(status) {
+		return errors.New("unknown request status")
+	}
+	result, err := config.DB.Exec(`
+		UPDATE license_requests
+		SET STATUS=$1, QUOTE=$2, NOTES=$3, UPDATED_AT=NOW(),
+			DELIVERED_AT=CASE WHEN $1 = 'delivered' THEN COALESCE(DELIVERED_AT, NOW()) END
+		WHERE ID=$4;`,
+		status, strings.TrimSpace(quote), strings.TrimSpace(notes), requestId)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+func DeleteRequest(requestId int) error {
+	_, err := config.DB.Exec("DELETE FROM license_requests WHERE id=$1;", requestId)
+	return err
+}
+
+// CountRequests returns how many requests have each status.
+func CountRequests() (map[string]int, error) {
+	counts := make(map[string]int)
+	rows, err := config.DB.Query("SELECT status, COUNT(*) FROM license_requests GROUP BY status;")
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"log"
 	"net/http"
+	"project/server/albums"
 	"project/server/config"
+	"project/server/licensing"
 	"project/server/objects"
 	"project/server/posts"
 	"project/server/users"
@@ -20,18 +22,67 @@ func contactHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func adminHandler(w http.ResponseWriter, req *http.Request) {
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	err := config.TPL.ExecuteTemplate(w, "admin.gohtml", loggedIn)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
 func main() {
+	go posts.PurgeTrashPeriodically(config.TrashRetention)
+	go posts.ExpireTusUploadsPeriodically(config.TusUploadExpiry)
+	go objects.CollectGarbagePeriodically(config.GCDryRun, config.GCGrace)
+	go objects.ScrubPeriodically(config.ScrubInterval)
+
 	http.HandleFunc("/archive/", posts.ArchiveHandler)
+	http.HandleFunc("/timeline", posts.TimelineHandler)
+	http.HandleFunc("/onthisday", posts.OnThisDayHandler)
+	http.HandleFunc("/random", posts.RandomHandler)
+	http.HandleFunc("/map", posts.MapHandler)
+	http.HandleFunc("/geojson", posts.GeoJSONHandler)
 	http.HandleFunc("/login", users.LoginHandler)
 	http.HandleFunc("/contact", contactHandler)
 	http.HandleFunc("/logout", users.LogoutHandler)
 	http.HandleFunc("/", posts.TagRepHandler)
 	// http.HandleFunc("/register", users.RegisterHandler)
 	http.HandleFunc("/upload", posts.UploadHandler)
+	http.HandleFunc("/files/", posts.TusHandler)
+	http.HandleFunc("/review", posts.ReviewHandler)
+	http.HandleFunc("/post/", posts.PostHandler)
 	http.HandleFunc("/update/", posts.UpdateHandler)
-	http.HandleFunc("/blob/", objects.ImageHandler)
+	http.HandleFunc("/revert/", posts.RevertHandler)
+	http.HandleFunc("/regions/", posts.RegionsHandler)
+	http.HandleFunc("/xmp/", posts.XMPHandler)
+	http.HandleFunc("/people", posts.PeopleHandler)
+	http.HandleFunc("/people/", posts.PersonHandler)
+	http.HandleFunc("/albums", albums.AlbumsHandler)
+	http.HandleFunc("/albums/", albums.AlbumHandler)
+	http.HandleFunc("/albums/edit/", albums.EditAlbumHandler)
+	http.HandleFunc("/albums/add", albums.AddToAlbumHandler)
+	http.HandleFunc("/blob/", posts.ImageHandler)
+	http.HandleFunc("/download/", posts.DownloadHandler)
+	http.HandleFunc("/downloads/", posts.DownloadLinkHandler)
+	http.HandleFunc("/request", licensing.RequestHandler)
+	http.HandleFunc("/request/", licensing.RequestStatusHandler)
+	http.HandleFunc("/requests", licensing.InboxHandler)
+	http.HandleFunc("/requests/", licensing.InboxRequestHandler)
 	http.HandleFunc("/delete/", posts.DeleteHandler)
+	http.HandleFunc("/trash", posts.TrashHandler)
+	http.HandleFunc("/trash/", posts.TrashHandler)
+	http.HandleFunc("/admin", adminHandler)
+	http.HandleFunc("/admin/gc", objects.GarbageHandler)
+	http.HandleFunc("/admin/scrub", objects.ScrubHandler)
+	http.HandleFunc("/admin/tags", posts.TagsHandler)
+	http.HandleFunc("/tags/suggest", posts.TagSuggestHandler)
+	http.HandleFunc("/metrics", objects.MetricsHandler)
 	http.HandleFunc("/style.css", styleSheetHandler)
+	http.Handle("/vendor/", http.StripPrefix("/vendor/", http.FileServer(http.Dir("public/vendor"))))
 	http.Handle("/favicon.ico", http.NotFoundHandler())
 	log.Fatal(http.ListenAndServe(":80", nil))
 }
@@ -0,0 +1,101 @@
+package objects
+
+import (
+	"log"
+	"project/server/config"
+	"sort"
+	"time"
+
+	"github.com/minio/minio-go"
+)
+
+// GCReport is the outcome of reconciling the bucket with posts.minio_url.
+type GCReport struct {
+	CheckedAt time.Time
+	DryRun    bool
+	Grace     time.Duration
+	// Orphans are objects no post refers to.
+	Orphans []minio.ObjectInfo
+	// Dangling are object names posts refer to that are missing.
+	Dangling []string
+	// Deleted are the orphans removed because they were older than Grace.
+	Deleted []string
+}
+
+// CollectGarbage lists every object in the bucket and every object name in
+// posts, reports the differences and, unless dryRun is set, removes orphans
+// older than grace. Derivatives are orphans once their original is.
+func CollectGarbage(dryRun bool, grace time.Duration) (GCReport, error) {
+	report := GCReport{CheckedAt: time.Now(), DryRun: dryRun, Grace: grace}
+	referenced, err := referencedObjects()
+	if err != nil {
+		return report, err
+	}
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		return report, err
+	}
+
+	found := map[string]bool{}
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for object := range minioClient.ListObjectsV2(Bucket, "", true, doneCh) {
+		if object.Err != nil {
+			return report, object.Err
+		}
+		found[object.Key] = true
+		if referenced[object.Key] {
+			continue
+		}
+		// derivatives live as long as their original
+		if original, ok := derivativeOf(object.Key); ok && referenced[original] {
+			continue
+		}
+		report.Orphans = append(report.Orphans, object)
+		if dryRun || object.LastModified.After(report.CheckedAt.Add(-grace)) {
+			continue
+		}
+		if err := minioClient.RemoveObject(Bucket, object.Key); err != nil {
+			return report, err
+		}
+		report.Deleted = append(report.Deleted, object.Key)
+	}
+	for objectName := range referenced {
+		if !found[objectName] {
+			report.Dangling = append(report.Dangling, objectName)
+		}
+	}
+	sort.Strings(report.Dangling)
+	return report, nil
+}
+
+func referencedObjects() (map[string]bool, error) {
+	referenced := map[string]bool{}
+	rows, err := config.DB.Query("SELECT minio_url FROM posts;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var objectName string
+		if err := rows.Scan(&objectName); err != nil {
+			return nil, err
+		}
+		referenced[objectName] = true
+	}
+	return referenced, rows.Err()
+}
+
+// CollectGarbagePeriodically reconciles the bucket once a day and logs what
+// it found.
+func CollectGarbagePeriodically(dryRun bool, grace time.Duration) {
+	for {
+		report, err := CollectGarbage(dryRun, grace)
+		if err != nil {
+			log.Printf("error collecting garbage: %v", err)
+		} else {
+			log.Printf("garbage collection: %d orphans, %d dangling references, %d deleted", len(report.Orphans), len(report.Dangling), len(report.Deleted))
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
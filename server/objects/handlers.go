@@ -1,39 +1,136 @@
 package objects
 
 import (
-	"io"
-	"mime"
+	"fmt"
+	"log"
+	"net"
 	"net/http"
-	"path/filepath"
 	"project/server/config"
-
-	"github.com/minio/minio-go"
+	"project/server/users"
+	"strings"
 )
 
-func ImageHandler(w http.ResponseWriter, req *http.Request) {
-	// Extract the filename from the URL path
-	filename := req.URL.Path[len("/blob/"):]
-
-	minioClient, err := config.NewMinIO()
+// GarbageHandler shows a dry-run report of orphaned objects and dangling
+// references, and removes orphans past the grace period on POST.
+func GarbageHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Report   GCReport
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	dryRun := req.Method != http.MethodPost
+	report, err := CollectGarbage(dryRun, config.GCGrace)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println(err)
+		http.Error(w, "error collecting garbage", http.StatusInternalServerError)
 		return
 	}
+	d := data{
+		Report:   report,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "gc.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
 
-	// Set the response header to indicate the content type
-	contentType := mime.TypeByExtension(filepath.Ext(filename))
-	w.Header().Set("Content-Type", contentType)
-
-	// Get the object from Minio and stream it to the response body
-	object, err := minioClient.GetObject("download", filename, minio.GetObjectOptions{})
+// ScrubHandler shows the outcome of the integrity scrubber and starts a new
+// scrub in the background on POST.
+func ScrubHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Summary  ScrubSummary
+		Problems []ScrubResult
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if req.Method == http.MethodPost {
+		go func() {
+			if _, err := Scrub(); err != nil {
+				log.Printf("error scrubbing storage: %v", err)
+			}
+		}()
+		http.Redirect(w, req, "/admin/scrub", http.StatusSeeOther)
+		return
+	}
+	summary, err := GetScrubSummary()
+	if err != nil {
+		http.Error(w, "error retrieving scrub results from the database", http.StatusInternalServerError)
+		return
+	}
+	problems, err := ListScrubProblems()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "error retrieving scrub results from the database", http.StatusInternalServerError)
 		return
 	}
-	defer object.Close()
+	d := data{
+		Summary:  summary,
+		Problems: problems,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "scrub.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
 
-	if _, err := io.Copy(w, object); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// MetricsHandler exposes the scrub results in the Prometheus text format to
+// editors and to the networks allowed to scrape them.
+func MetricsHandler(w http.ResponseWriter, req *http.Request) {
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn && !metricsAllowed(req.RemoteAddr, config.MetricsNetworks) {
+		http.Error(w, "Permission denied.", http.StatusForbidden)
 		return
 	}
+	summary, err := GetScrubSummary()
+	if err != nil {
+		http.Error(w, "error retrieving scrub results from the database", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP archive_scrub_objects Objects per outcome of their last integrity check.")
+	fmt.Fprintln(w, "# TYPE archive_scrub_objects gauge")
+	for _, status := range scrubStatuses {
+		fmt.Fprintf(w, "archive_scrub_objects{status=%q} %d\n", status, summary.Counts[status])
+	}
+	fmt.Fprintln(w, "# HELP archive_scrub_running Whether a scrub is in progress.")
+	fmt.Fprintln(w, "# TYPE archive_scrub_running gauge")
+	running := 0
+	if summary.Running {
+		running = 1
+	}
+	fmt.Fprintf(w, "archive_scrub_running %d\n", running)
+	if summary.LastCheck.Valid {
+		fmt.Fprintln(w, "# HELP archive_scrub_last_check_timestamp_seconds Time of the most recent object check.")
+		fmt.Fprintln(w, "# TYPE archive_scrub_last_check_timestamp_seconds gauge")
+		fmt.Fprintf(w, "archive_scrub_last_check_timestamp_seconds %d\n", summary.LastCheck.Time.Unix())
+	}
+}
+
+// metricsAllowed reports whether the client at remoteAddr is in one of the
+// comma separated networks.
+func metricsAllowed(remoteAddr, networks string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range strings.Split(networks, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(network))
+		if err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
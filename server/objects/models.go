@@ -0,0 +1,125 @@
+package objects
+
+import (
+	"bytes"
+	"io"
+	"project/server/config"
+	"strings"
+
+	"github.com/minio/minio-go"
+)
+
+// Bucket holds every object of the archive.
+const Bucket = "download"
+
+// partSize is the amount of data buffered per part when streaming an object
+// of unknown length. S3 requires all parts but the last to be at least 5 MiB.
+const partSize = 16 << 20
+
+// PutStream uploads r as objectName without knowing its length in advance,
+// keeping at most one part in memory.
+func PutStream(client *minio.Client, objectName string, r io.Reader, contentType string) (int64, error) {
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return client.PutObject(Bucket, objectName, bytes.NewReader(buf[:n]), int64(n), opts)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	core := minio.Core{Client: client}
+	uploadID, err := core.NewMultipartUpload(Bucket, objectName, opts)
+	if err != nil {
+		return 0, err
+	}
+	var parts []minio.CompletePart
+	var size int64
+	for partNumber := 1; n > 0; partNumber++ {
+		part, err := core.PutObjectPart(Bucket, objectName, uploadID, partNumber, bytes.NewReader(buf[:n]), int64(n), "", "", nil)
+		if err != nil {
+			core.AbortMultipartUpload(Bucket, objectName, uploadID)
+			return size, err
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		size += int64(n)
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			core.AbortMultipartUpload(Bucket, objectName, uploadID)
+			return size, err
+		}
+	}
+	_, err = core.CompleteMultipartUpload(Bucket, objectName, uploadID, parts)
+	if err != nil {
+		core.AbortMultipartUpload(Bucket, objectName, uploadID)
+	}
+	return size, err
+}
+
+// Rename moves an object within the bucket using a server-side copy and sets
+// its content type.
+func Rename(client *minio.Client, src, dst string, contentType string) error {
+	meta := map[string]string{"Content-Type": contentType}
+	dstInfo, err := minio.NewDestinationInfo(Bucket, dst, nil, meta)
+	if err != nil {
+		return err
+	}
+	err = client.CopyObject(dstInfo, minio.NewSourceInfo(Bucket, src, nil))
+	if err != nil {
+		return err
+	}
+	return client.RemoveObject(Bucket, src)
+}
+
+// DerivativePrefix holds the derivatives rendered from the objects, each
+// under the name of its original so it goes when the original goes.
+const DerivativePrefix = "derivatives/"
+
+// DerivativesOf returns the prefix of the derivatives of an object.
+func DerivativesOf(objectName string) string {
+	return DerivativePrefix + objectName + "/"
+}
+
+// derivativeOf returns the object a derivative was rendered from.
+func derivativeOf(objectName string) (string, bool) {
+	if !strings.HasPrefix(objectName, DerivativePrefix) {
+		return "", false
+	}
+	original := strings.TrimPrefix(objectName, DerivativePrefix)
+	i := strings.LastIndex(original, "/")
+	if i < 0 {
+		return "", false
+	}
+	return original[:i], true
+}
+
+// RemoveDerivatives deletes the derivatives of an object, except keep.
+func RemoveDerivatives(client *minio.Client, objectName, keep string) error {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	for object := range client.ListObjectsV2(Bucket, DerivativesOf(objectName), true, doneCh) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if object.Key == keep {
+			continue
+		}
+		if err := client.RemoveObject(Bucket, object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes an object and its derivatives from the bucket.
+func Remove(objectName string) error {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		return err
+	}
+	if err := minioClient.RemoveObject(Bucket, objectName); err != nil {
+		return err
+	}
+	return RemoveDerivatives(minioClient, objectName, "")
+}
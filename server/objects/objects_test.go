@@ -0,0 +1,241 @@
+package objects
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"fmt"
+	"io"
+	"project/server/config"
+	"project/server/users"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go"
+)
+
+func TestExpectedHash(t *testing.T) {
+	tests := []struct {
+		objectName string
+		hash       string
+		ok         bool
+	}{
+		{"1968/0a4d55a8d778e5022fab701977c5d840bbc486d0.jpg", "0a4d55a8d778e5022fab701977c5d840bbc486d0", true},
+		{"0a4d55a8d778e5022fab701977c5d840bbc486d0", "0a4d55a8d778e5022fab701977c5d840bbc486d0", true},
+		{"1968/30613464353561386437373865353032326661623730313937376335643834306262633438366430.jpg", "0a4d55a8d778e5022fab701977c5d840bbc486d0", true},
+		{"1968/kermis.jpg", "kermis", false},
+		{"1968/0A4D55A8D778E5022FAB701977C5D840BBC486D0.jpg", "0A4D55A8D778E5022FAB701977C5D840BBC486D0", false},
+	}
+	for _, test := range tests {
+		hash, ok := expectedHash(test.objectName)
+		if hash != test.hash || ok != test.ok {
+			t.Errorf("expectedHash(%q) = %q %v, expected %q %v", test.objectName, hash, ok, test.hash, test.ok)
+		}
+	}
+}
+
+func TestHashObject(t *testing.T) {
+	content := bytes.Repeat([]byte("kermis"), 20000)
+	h := sha1.New()
+	h.Write(content)
+	// the hash the first uploads were named with
+	legacyHash := sha1.New()
+	io.Copy(legacyHash, io.TeeReader(bytes.NewReader(content), legacyHash))
+
+	actual, legacy, err := hashObject(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != fmt.Sprintf("%x", h.Sum(nil)) {
+		t.Errorf("Expected the SHA-1 of the content, got %s.", actual)
+	}
+	if legacy != fmt.Sprintf("%x", legacyHash.Sum(nil)) {
+		t.Errorf("Expected the hash of the first uploads, got %s.", legacy)
+	}
+}
+
+// legacyObjectName names content the way storeFiles did before names were
+// hashed once: the hex of the hex of a SHA-1 fed every chunk twice.
+func legacyObjectName(year string, content []byte, ext string) string {
+	h := sha1.New()
+	io.Copy(h, io.TeeReader(bytes.NewReader(content), h))
+	hashSum := fmt.Sprintf("%x", h.Sum(nil))
+	return year + "/" + fmt.Sprintf("%x", hashSum) + "." + ext
+}
+
+func TestLegacyObjectName(t *testing.T) {
+	content := bytes.Repeat([]byte("kermis"), 20000)
+	objectName := legacyObjectName("1965", content, "jpg")
+	hash, ok := expectedHash(objectName)
+	if !ok {
+		t.Fatalf("The name of a first upload should carry its hash: %s", objectName)
+	}
+	actual, legacy, _ := hashObject(bytes.NewReader(content))
+	if status := compareHashes(hash, ok, actual, legacy); status != ScrubOK {
+		t.Errorf("A first upload should verify, got %s.", status)
+	}
+}
+
+func TestCompareHashes(t *testing.T) {
+	tests := []struct {
+		Description string
+		Expected    string
+		Verifiable  bool
+		Status      string
+	}{
+		{"hash matches", "actual", true, ScrubOK},
+		{"legacy hash matches", "legacy", true, ScrubOK},
+		{"neither matches", "other", true, ScrubMismatch},
+		{"name without hash", "", false, ScrubUnverifiable},
+	}
+	for _, test := range tests {
+		if status := compareHashes(test.Expected, test.Verifiable, "actual", "legacy"); status != test.Status {
+			t.Errorf("Test '%s' failed because status %s was expected instead of %s.", test.Description, test.Status, status)
+		}
+	}
+}
+
+func TestScrubObject(t *testing.T) {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("kermis 1965")
+	hash := fmt.Sprintf("%x", sha1.Sum(content))
+	put := func(objectName string, content []byte) {
+		_, err := minioClient.PutObject(Bucket, objectName, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	intact := "1965/" + hash + ".jpg"
+	corrupt := "1966/" + hash + ".jpg"
+	legacy := "1967/kermis.jpg"
+	firstUpload := legacyObjectName("1964", content, "jpg")
+	put(intact, content)
+	put(corrupt, []byte("kermis 1966"))
+	put(legacy, content)
+	put(firstUpload, content)
+	defer Remove(intact)
+	defer Remove(corrupt)
+	defer Remove(legacy)
+	defer Remove(firstUpload)
+
+	tests := map[string]string{
+		intact:                  ScrubOK,
+		corrupt:                 ScrubMismatch,
+		legacy:                  ScrubUnverifiable,
+		firstUpload:             ScrubOK,
+		"1968/" + hash + ".jpg": ScrubMissing,
+	}
+	for objectName, status := range tests {
+		result := scrubObject(minioClient, ScrubResult{ObjectName: objectName, PostId: sql.NullInt64{Int64: 1, Valid: true}})
+		if result.Status != status {
+			t.Errorf("Expected %s to be %s, got %s: %s", objectName, status, result.Status, result.Message)
+		}
+	}
+}
+
+func TestMetricsAllowed(t *testing.T) {
+	networks := "127.0.0.0/8, ::1/128,10.0.0.0/8"
+	tests := map[string]bool{
+		"127.0.0.1:51234":   true,
+		"[::1]:51234":       true,
+		"10.1.2.3:9090":     true,
+		"192.168.1.10:9090": false,
+		"[2001:db8::1]:80":  false,
+		"not an address":    false,
+	}
+	for remoteAddr, allowed := range tests {
+		if metricsAllowed(remoteAddr, networks) != allowed {
+			t.Errorf("Expected %s to be allowed: %v", remoteAddr, allowed)
+		}
+	}
+	if metricsAllowed("127.0.0.1:51234", "") {
+		t.Error("Without networks only editors should read the metrics.")
+	}
+}
+
+func TestDerivativeOf(t *testing.T) {
+	tests := []struct {
+		objectName string
+		original   string
+		ok         bool
+	}{
+		{DerivativesOf("1965/kermis.jpg") + "abc.jpg", "1965/kermis.jpg", true},
+		{"1965/kermis.jpg", "", false},
+		{"derivatives/kermis.jpg", "", false},
+	}
+	for _, test := range tests {
+		original, ok := derivativeOf(test.objectName)
+		if original != test.original || ok != test.ok {
+			t.Errorf("derivativeOf(%q) = %q %v, expected %q %v", test.objectName, original, ok, test.original, test.ok)
+		}
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		t.Fatal(err)
+	}
+	referenced := "1965/gc-referenced.jpg"
+	derivative := DerivativesOf(referenced) + "abc.jpg"
+	orphan := "1965/gc-orphan.jpg"
+	dangling := "1965/gc-dangling.jpg"
+	for _, objectName := range []string{referenced, derivative, orphan} {
+		_, err := minioClient.PutObject(Bucket, objectName, bytes.NewReader([]byte("kermis")), 6, minio.PutObjectOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer Remove(referenced)
+	defer Remove(orphan)
+	for _, objectName := range []string{referenced, dangling} {
+		config.DB.Exec("INSERT INTO posts (MINIO_URL, YEAR, USER_ID) VALUES ($1, 1965, 1);", objectName)
+	}
+	orphaned := func(report GCReport, objectName string) bool {
+		for _, object := range report.Orphans {
+			if object.Key == objectName {
+				return true
+			}
+		}
+		return false
+	}
+	contains := func(names []string, objectName string) bool {
+		for _, name := range names {
+			if name == objectName {
+				return true
+			}
+		}
+		return false
+	}
+
+	report, err := CollectGarbage(true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !orphaned(report, orphan) || orphaned(report, referenced) || orphaned(report, derivative) {
+		t.Errorf("Only unreferenced objects should be orphans, got %v.", report.Orphans)
+	}
+	if !contains(report.Dangling, dangling) || contains(report.Dangling, referenced) {
+		t.Errorf("Missing objects of posts should be dangling, got %v.", report.Dangling)
+	}
+	if len(report.Deleted) != 0 {
+		t.Error("A dry run should not delete anything.")
+	}
+
+	report, _ = CollectGarbage(false, time.Hour)
+	if contains(report.Deleted, orphan) {
+		t.Error("Orphans within the grace period should be kept.")
+	}
+	report, _ = CollectGarbage(false, 0)
+	if !contains(report.Deleted, orphan) || contains(report.Deleted, referenced) || contains(report.Deleted, derivative) {
+		t.Errorf("Only the orphan should be deleted, got %v.", report.Deleted)
+	}
+	if _, err := minioClient.StatObject(Bucket, derivative, minio.StatObjectOptions{}); err != nil {
+		t.Errorf("The derivative of a referenced object should be kept: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package objects
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"project/server/config"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go"
+)
+
+// Outcomes of checking a single object.
+const (
+	ScrubOK           = "ok"
+	ScrubMismatch     = "mismatch"
+	ScrubMissing      = "missing"
+	ScrubUnverifiable = "unverifiable"
+	ScrubError        = "error"
+)
+
+var scrubStatuses = []string{ScrubOK, ScrubMismatch, ScrubMissing, ScrubUnverifiable, ScrubError}
+
+// sha1Name matches the hash part of content addressed object names.
+var sha1Name = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// legacyName matches the hash part of the names of the first uploads, which
+// hex encoded the hex SHA-1 once more.
+var legacyName = regexp.MustCompile(`^[0-9a-f]{80}$`)
+
+type ScrubResult struct {
+	ObjectName   string
+	PostId       sql.NullInt64
+	Status       string
+	ExpectedHash string
+	ActualHash   string
+	Message      string
+	CheckedAt    time.Time
+}
+
+type ScrubSummary struct {
+	Counts    map[string]int
+	LastCheck sql.NullTime
+	Running   bool
+}
+
+var scrubbing sync.Mutex
+
+// expectedHash extracts the SHA-1 embedded in an object name such as
+// 1968/<sha1>.jpg, decoding the doubly encoded names of the first uploads.
+// Objects stored before names were content addressed have no usable hash.
+func expectedHash(objectName string) (string, bool) {
+	base := path.Base(objectName)
+	hash := strings.TrimSuffix(base, path.Ext(base))
+	if legacyName.MatchString(hash) {
+		decoded, err := hex.DecodeString(hash)
+		if err == nil && sha1Name.Match(decoded) {
+			return string(decoded), true
+		}
+	}
+	return hash, sha1Name.MatchString(hash)
+}
+
+// legacyChunkSize is the buffer io.Copy hashed the first uploads with.
+const legacyChunkSize = 32 * 1024
+
+// hashObject returns the SHA-1 of r, and the hash the first uploads were
+// named with: those fed every 32 KiB chunk to the hash twice, through both
+// io.Copy and an io.TeeReader.
+func hashObject(r io.Reader) (string, string, error) {
+	h, legacy := sha1.New(), sha1.New()
+	buf := make([]byte, legacyChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		h.Write(buf[:n])
+		legacy.Write(buf[:n])
+		legacy.Write(buf[:n])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), fmt.Sprintf("%x", legacy.Sum(nil)), nil
+}
+
+// Scrub re-reads every object referenced by a post, recomputes its hash and
+// records the outcome. It returns false if a scrub is already running.
+func Scrub() (bool, error) {
+	if !scrubbing.TryLock() {
+		return false, nil
+	}
+	defer scrubbing.Unlock()
+
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		return true, err
+	}
+	rows, err := config.DB.Query("SELECT id, minio_url FROM posts ORDER BY id;")
+	if err != nil {
+		return true, err
+	}
+	var results []ScrubResult
+	for rows.Next() {
+		result := ScrubResult{}
+		if err := rows.Scan(&result.PostId, &result.ObjectName); err != nil {
+			rows.Close()
+			return true, err
+		}
+		results = append(results, result)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return true, err
+	}
+	for _, result := range results {
+		result = scrubObject(minioClient, result)
+		if err := saveScrubResult(result); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func scrubObject(minioClient *minio.Client, result ScrubResult) ScrubResult {
+	result.CheckedAt = time.Now()
+	hash, verifiable := expectedHash(result.ObjectName)
+	if verifiable {
+		result.ExpectedHash = hash
+	}
+	object, err := minioClient.GetObject(Bucket, result.ObjectName, minio.GetObjectOptions{})
+	if err != nil {
+		result.Status = ScrubError
+		result.Message = err.Error()
+		return result
+	}
+	defer object.Close()
+	actual, legacy, err := hashObject(object)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			result.Status = ScrubMissing
+		} else {
+			result.Status = ScrubError
+			result.Message = err.Error()
+		}
+		return result
+	}
+	result.ActualHash = actual
+	result.Status = compareHashes(result.ExpectedHash, verifiable, actual, legacy)
+	if result.Status == ScrubOK && actual != result.ExpectedHash {
+		result.ActualHash = legacy
+		result.Message = "verified with the hash of the first uploads"
+	}
+	return result
+}
+
+// compareHashes decides the outcome of a check from the hash in the object
+// name and the hashes of its content.
+func compareHashes(expected string, verifiable bool, actual, legacy string) string {
+	switch {
+	case !verifiable:
+		return ScrubUnverifiable
+	case actual == expected || legacy == expected:
+		return ScrubOK
+	default:
+		return ScrubMismatch
+	}
+}
+
+func saveScrubResult(result ScrubResult) error {
+	_, err := config.DB.Exec(`
+		INSERT INTO scrub_results (OBJECT_NAME, POST_ID, STATUS, EXPECTED_HASH, ACTUAL_HASH, MESSAGE, CHECKED_AT)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (object_name) DO UPDATE
+		SET POST_ID = EXCLUDED.post_id,
+			STATUS = EXCLUDED.status,
+			EXPECTED_HASH = EXCLUDED.expected_hash,
+			ACTUAL_HASH = EXCLUDED.actual_hash,
+			MESSAGE = EXCLUDED.message,
+			CHECKED_AT = EXCLUDED.checked_at;
+		`,
+		result.ObjectName, result.PostId, result.Status, result.ExpectedHash, result.ActualHash, result.Message, result.CheckedAt)
+	return err
+}
+
+// ListScrubProblems returns the objects whose last check was not ok.
+func ListScrubProblems() ([]ScrubResult, error) {
+	results := make([]ScrubResult, 0)
+	rows, err := config.DB.Query(`
+		SELECT object_name, post_id, status, expected_hash, actual_hash, message, checked_at
+		FROM scrub_results
+		WHERE status IN ($1, $2, $3)
+		ORDER BY checked_at DESC;
+		`, ScrubMismatch, ScrubMissing, ScrubError)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result := ScrubResult{}
+		err := rows.Scan(&result.ObjectName, &result.PostId, &result.Status, &result.ExpectedHash, &result.ActualHash, &result.Message, &result.CheckedAt)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func GetScrubSummary() (ScrubSummary, error) {
+	summary := ScrubSummary{Counts: map[string]int{}}
+	for _, status := range scrubStatuses {
+		summary.Counts[status] = 0
+	}
+	rows, err := config.DB.Query("SELECT status, COUNT(*) FROM scrub_results GROUP BY status;")
+	if err != nil {
+		return summary, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return summary, err
+		}
+		summary.Counts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return summary, err
+	}
+	err = config.DB.QueryRow("SELECT MAX(checked_at) FROM scrub_results;").Scan(&summary.LastCheck)
+	if scrubbing.TryLock() {
+		scrubbing.Unlock()
+	} else {
+		summary.Running = true
+	}
+	return summary, err
+}
+
+// ScrubPeriodically verifies the whole archive every interval.
+func ScrubPeriodically(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for {
+		time.Sleep(interval)
+		if _, err := Scrub(); err != nil {
+			log.Printf("error scrubbing storage: %v", err)
+		}
+	}
+}
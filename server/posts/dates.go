@@ -0,0 +1,197 @@
+package posts
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Many archive photos are only known as "circa 1965" or "1970s", so a post
+// is dated with a range and the precision it is known to.
+const (
+	PrecisionDay    = "day"
+	PrecisionMonth  = "month"
+	PrecisionYear   = "year"
+	PrecisionDecade = "decade"
+	PrecisionCirca  = "circa"
+)
+
+// circaYears is how far "ca. 1965" reaches on either side.
+const circaYears = 2
+
+// minDateYear rejects typos such as 165 for 1965.
+const minDateYear = 1800
+
+var (
+	dayDate    = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	monthDate  = regexp.MustCompile(`^\d{4}-\d{2}$`)
+	yearDate   = regexp.MustCompile(`^(\d{4})$`)
+	decadeDate = regexp.MustCompile(`^(\d{3})0s$`)
+	circaDate  = regexp.MustCompile(`^(?:ca\.?|circa|c\.)\s*(\d{4})$`)
+	rangeDate  = regexp.MustCompile(`^(\d{4})\s*-\s*(\d{4})$`)
+)
+
+// chronologicalOrder sorts posts by the middle of their date range, putting
+// the more precisely dated of two posts with the same middle first and
+// undated posts last.
+const chronologicalOrder = `p.date_start + (p.date_end - p.date_start) / 2 NULLS LAST, p.date_end - p.date_start, p.id`
+
+// FuzzyDate is the period a photo was taken in, from the first to the last
+// day it may have been taken on.
+type FuzzyDate struct {
+	Precision string
+	Start     time.Time
+	End       time.Time
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// yearsDate covers the whole years from first to last.
+func yearsDate(precision string, first, last int) FuzzyDate {
+	return FuzzyDate{Precision: precision, Start: date(first, time.January, 1), End: date(last, time.December, 31)}
+}
+
+// dateOfYear dates a post to a year, as posts were before dates had a
+// precision. Year 0 stands for an unknown date.
+func dateOfYear(year int) FuzzyDate {
+	if year <= 0 {
+		return FuzzyDate{Precision: PrecisionYear}
+	}
+	return yearsDate(PrecisionYear, year, year)
+}
+
+// parseFuzzyDate accepts 1965-07-14, 1965-07, 1965, 1960s, ca. 1965 and
+// 1963-1967.
+func parseFuzzyDate(value string) (FuzzyDate, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	var d FuzzyDate
+	switch {
+	case dayDate.MatchString(value):
+		start, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return d, err
+		}
+		d = FuzzyDate{Precision: PrecisionDay, Start: start, End: start}
+	case monthDate.MatchString(value):
+		start, err := time.Parse("2006-01", value)
+		if err != nil {
+			return d, err
+		}
+		d = FuzzyDate{Precision: PrecisionMonth, Start: start, End: start.AddDate(0, 1, -1)}
+	case yearDate.MatchString(value):
+		year, _ := strconv.Atoi(value)
+		d = yearsDate(PrecisionYear, year, year)
+	case decadeDate.MatchString(value):
+		tens, _ := strconv.Atoi(decadeDate.FindStringSubmatch(value)[1])
+		d = yearsDate(PrecisionDecade, tens*10, tens*10+9)
+	case circaDate.MatchString(value):
+		year, _ := strconv.Atoi(circaDate.FindStringSubmatch(value)[1])
+		d = yearsDate(PrecisionCirca, year-circaYears, year+circaYears)
+	case rangeDate.MatchString(value):
+		match := rangeDate.FindStringSubmatch(value)
+		first, _ := strconv.Atoi(match[1])
+		last, _ := strconv.Atoi(match[2])
+		if first > last {
+			return d, fmt.Errorf("date range %s ends before it starts", value)
+		}
+		d = yearsDate(PrecisionCirca, first, last)
+	default:
+		return d, fmt.Errorf("date %q is not a day, month, year, decade, circa year or range of years", value)
+	}
+	return d, d.checkBounds(value)
+}
+
+// checkBounds rejects dates before minDateYear or in the future.
+func (d FuzzyDate) checkBounds(value string) error {
+	if d.Start.Year() < minDateYear {
+		return fmt.Errorf("date %s lies before %d", value, minDateYear)
+	}
+	if d.Start.After(time.Now()) {
+		return fmt.Errorf("date %s lies in the future", value)
+	}
+	return nil
+}
+
+// parseDateField reads a date from a form, falling back to the year field
+// older forms and clients send.
+func parseDateField(value, year string) (FuzzyDate, error) {
+	if strings.TrimSpace(value) != "" {
+		return parseFuzzyDate(value)
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(year))
+	if err != nil {
+		return FuzzyDate{}, fmt.Errorf("'year' is not an integer: %v", err)
+	}
+	d := dateOfYear(y)
+	return d, d.checkBounds(strconv.Itoa(y))
+}
+
+// Known reports whether the post has a date at all.
+func (d FuzzyDate) Known() bool {
+	return !d.Start.IsZero()
+}
+
+// Year is the year the post is listed under in the archive: the middle of a
+// circa range and otherwise the year it starts in.
+func (d FuzzyDate) Year() int {
+	if !d.Known() {
+		return 0
+	}
+	if d.Precision == PrecisionCirca {
+		return (d.Start.Year() + d.End.Year()) / 2
+	}
+	return d.Start.Year()
+}
+
+// String formats the date the way parseFuzzyDate reads it.
+func (d FuzzyDate) String() string {
+	if !d.Known() {
+		return ""
+	}
+	switch d.Precision {
+	case PrecisionDay:
+		return d.Start.Format("2006-01-02")
+	case PrecisionMonth:
+		return d.Start.Format("2006-01")
+	case PrecisionDecade:
+		return fmt.Sprintf("%ds", d.Start.Year())
+	case PrecisionCirca:
+		if d.End.Year()-d.Start.Year() == 2*circaYears {
+			return fmt.Sprintf("ca. %d", d.Year())
+		}
+		return fmt.Sprintf("%d-%d", d.Start.Year(), d.End.Year())
+	}
+	return strconv.Itoa(d.Start.Year())
+}
+
+// bounds returns the start and end for storing, NULL for an unknown date.
+func (d FuzzyDate) bounds() (sql.NullTime, sql.NullTime) {
+	if !d.Known() {
+		return sql.NullTime{}, sql.NullTime{}
+	}
+	return sql.NullTime{Time: d.Start, Valid: true}, sql.NullTime{Time: d.End, Valid: true}
+}
+
+// decade groups the years of the archive that fall in one decade.
+type decade struct {
+	Start int
+	Years []int
+}
+
+// groupDecades groups sorted years by decade.
+func groupDecades(years []int) []decade {
+	decades := make([]decade, 0)
+	for _, year := range years {
+		start := year - year%10
+		if len(decades) == 0 || decades[len(decades)-1].Start != start {
+			decades = append(decades, decade{Start: start})
+		}
+		decades[len(decades)-1].Years = append(decades[len(decades)-1].Years, year)
+	}
+	return decades
+}
@@ -0,0 +1,98 @@
+package posts
+
+import (
+	"database/sql"
+	"hash/fnv"
+	"project/server/config"
+	"time"
+)
+
+// ListOnThisDay returns the visible posts taken on the day and month of day
+// in any year. Only posts dated to the day qualify.
+func ListOnThisDay(day time.Time, showAll bool) ([]Post, error) {
+	postSlice := make([]Post, 0)
+	rows, err := config.DB.Query(`
+		SELECT `+postColumns+`, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE `+VisibleCondition(showAll)+`
+		AND p.date_precision = $1
+		AND EXTRACT(MONTH FROM p.date_start) = $2
+		AND EXTRACT(DAY FROM p.date_start) = $3
+		GROUP BY p.id
+		ORDER BY p.date_start;
+		`, PrecisionDay, int(day.Month()), day.Day())
+	if err != nil {
+		return postSlice, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return postSlice, err
+		}
+		postSlice = append(postSlice, post)
+	}
+	return postSlice, rows.Err()
+}
+
+// randomPostId picks a visible post at random.
+func randomPostId(showAll bool) (int, error) {
+	var postId int
+	err := config.DB.QueryRow(`
+		SELECT p.id FROM posts p WHERE ` + VisibleCondition(showAll) + ` ORDER BY random() LIMIT 1;
+		`).Scan(&postId)
+	return postId, err
+}
+
+// featuredPostId chooses a photo of the day among the public posts. The
+// choice depends on the date and the public posts, so it changes as posts
+// are published; FeaturedPost stores it to keep it for the rest of the day.
+func featuredPostId(day time.Time) (int, error) {
+	key := day.Format("2006-01-02")
+	var count int
+	err := config.DB.QueryRow("SELECT COUNT(*) FROM posts p WHERE " + VisibleCondition(false) + ";").Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, sql.ErrNoRows
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	var postId int
+	err = config.DB.QueryRow(`
+		SELECT p.id FROM posts p WHERE `+VisibleCondition(false)+` ORDER BY p.id OFFSET $1 LIMIT 1;
+		`, int(h.Sum32()%uint32(count))).Scan(&postId)
+	return postId, err
+}
+
+// FeaturedPost returns the photo of the day, which is chosen once and stored
+// so every visitor and every server instance sees it all day. A stored choice
+// that is no longer public is replaced.
+func FeaturedPost(day time.Time) (Post, error) {
+	key := day.Format("2006-01-02")
+	var postId int
+	err := config.DB.QueryRow("SELECT post_id FROM featured_posts WHERE day=$1;", key).Scan(&postId)
+	if err == nil {
+		post, err := GetPost(postId)
+		if err == nil && post.Public() {
+			return post, nil
+		}
+	} else if err != sql.ErrNoRows {
+		return Post{}, err
+	}
+	postId, err = featuredPostId(day)
+	if err != nil {
+		return Post{}, err
+	}
+	_, err = config.DB.Exec(`
+		INSERT INTO featured_posts (DAY, POST_ID) VALUES ($1, $2)
+		ON CONFLICT (day) DO UPDATE SET POST_ID = EXCLUDED.post_id;`,
+		key, postId)
+	if err != nil {
+		return Post{}, err
+	}
+	return GetPost(postId)
+}
@@ -1,6 +1,9 @@
 package posts
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -8,6 +11,7 @@ import (
 	"project/server/config"
 	"project/server/users"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,33 +24,49 @@ func ArchiveHandler(w http.ResponseWriter, req *http.Request) {
 		Properties     template.URL
 		PrevProperties template.URL
 		NextProperties template.URL
-		Years          []int
-		Tag            string
+		// PeriodProperties keep the tag and search when picking a period.
+		PeriodProperties template.URL
+		Decades          []decade
+		Tag              string
+		Search           string
 	}
 	limit, offset, year, tag, err := queryURL(req)
 	if err != nil {
 		http.Error(w, "invalid limit and/or offset", http.StatusForbidden)
 	}
-	posts, first, last, err := ListPosts(limit, offset, year, tag)
+	search := strings.TrimSpace(req.URL.Query().Get("q"))
+	decadeStart, _ := strconv.Atoi(req.URL.Query().Get("decade"))
+	if tag != "" {
+		tag, err = resolveAlias(tag)
+		if err != nil {
+			http.Error(w, "error retrieving tags from the database", http.StatusInternalServerError)
+			return
+		}
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	filter := archiveFilter{Year: year, Decade: decadeStart, Tag: tag, Search: search, ShowAll: loggedIn}
+	posts, first, last, err := ListPosts(limit, offset, filter)
 	if err != nil {
 		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
 	}
-	_, loggedIn := users.GetLoginStatus(req)
-	years, err := listYears([]string{tag})
+	years, err := listYears([]string{tag}, loggedIn)
 	if err != nil {
 		http.Error(w, "error retrieving years from the database", http.StatusInternalServerError)
 	}
-	properties, prevProperties, nextProperties := createProperties(limit, offset, year, tag)
+	properties, prevProperties, nextProperties := createProperties(limit, offset, filter)
+	periodProperties, _, _ := createProperties(limit, 0, archiveFilter{Tag: tag, Search: search})
 	d := data{
-		Posts:          posts,
-		LoggedIn:       loggedIn,
-		First:          first,
-		Last:           last,
-		Properties:     properties,
-		PrevProperties: prevProperties,
-		NextProperties: nextProperties,
-		Years:          years,
-		Tag:            tag,
+		Posts:            posts,
+		LoggedIn:         loggedIn,
+		First:            first,
+		Last:             last,
+		Properties:       properties,
+		PrevProperties:   prevProperties,
+		NextProperties:   nextProperties,
+		PeriodProperties: periodProperties,
+		Decades:          groupDecades(years),
+		Tag:              tag,
+		Search:           search,
 	}
 	err = config.TPL.ExecuteTemplate(w, "archive.gohtml", d)
 	if err != nil {
@@ -54,41 +74,495 @@ func ArchiveHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// TimelineHandler shows the archive per decade with the number of photos and
+// a few thumbnails for every year.
+func TimelineHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Decades  []timelineDecade
+		Tag      string
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	tag, err := resolveAlias(req.URL.Query().Get("tag"))
+	if err != nil {
+		http.Error(w, "error retrieving tags from the database", http.StatusInternalServerError)
+		return
+	}
+	decades, err := listTimeline(tag, loggedIn)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving the timeline from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Decades:  decades,
+		Tag:      tag,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "timeline.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// MapHandler shows the located photos on a map, which loads them from
+// GeoJSONHandler for the area in view.
+func MapHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Tiles    mapTiles
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	d := data{
+		Tiles:    tiles(),
+		LoggedIn: loggedIn,
+	}
+	err := config.TPL.ExecuteTemplate(w, "map.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// GeoJSONHandler exports the located photos as a GeoJSON feature collection.
+// With a bbox parameter (minLon,minLat,maxLon,maxLat) it only returns the
+// photos in that area, at most maxMapPosts of them.
+func GeoJSONHandler(w http.ResponseWriter, req *http.Request) {
+	_, loggedIn := users.GetLoginStatus(req)
+	var box *boundingBox
+	limit := 0
+	if value := req.URL.Query().Get("bbox"); value != "" {
+		parsed, err := parseBoundingBox(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		box, limit = &parsed, maxMapPosts
+	}
+	postSlice, err := listLocatedPosts(box, loggedIn, limit)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	postIds := make([]int, len(postSlice))
+	for i, post := range postSlice {
+		postIds[i] = post.Id
+	}
+	provenance, err := listProvenance(postIds)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving provenance from the database", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	err = json.NewEncoder(w).Encode(newFeatureCollection(postSlice, provenance))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// OnThisDayHandler lists the photos taken on today's date, or on the day in
+// the date parameter (MM-DD), in earlier years.
+func OnThisDayHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Posts    []Post
+		Day      time.Time
+		Previous string
+		Next     string
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	now := time.Now()
+	day := time.Date(2000, now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if value := req.URL.Query().Get("date"); value != "" {
+		parsed, err := time.Parse("01-02", value)
+		if err != nil {
+			http.Error(w, "invalid date, use MM-DD", http.StatusBadRequest)
+			return
+		}
+		// a leap year, so 02-29 has neighbours
+		day = parsed.AddDate(2000, 0, 0)
+	}
+	posts, err := ListOnThisDay(day, loggedIn)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Posts:    posts,
+		Day:      day,
+		Previous: day.AddDate(0, 0, -1).Format("01-02"),
+		Next:     day.AddDate(0, 0, 1).Format("01-02"),
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "onthisday.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// RandomHandler redirects to a random photo.
+func RandomHandler(w http.ResponseWriter, req *http.Request) {
+	_, loggedIn := users.GetLoginStatus(req)
+	postId, err := randomPostId(loggedIn)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, req, fmt.Sprintf("/post/%d", postId), http.StatusFound)
+}
+
 func UploadHandler(w http.ResponseWriter, req *http.Request) {
 	type data struct {
-		LoggedIn    bool
-		CurrentYear int
+		LoggedIn bool
+		Results  []uploadResult
 	}
 	_, loggedIn := users.GetLoginStatus(req)
 	if !loggedIn {
 		http.Redirect(w, req, "/login", http.StatusSeeOther)
 		return
 	}
+	d := data{
+		LoggedIn: loggedIn,
+	}
 	if req.Method == http.MethodPost {
-		err := storeFiles(req)
+		results, err := storeFiles(w, req)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("upload exceeds the maximum of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "error storing file object ", http.StatusInternalServerError)
 			return
 		}
-		http.Redirect(w, req, fmt.Sprintf("?year=%s", req.PostFormValue("year")), http.StatusSeeOther)
+		failed, tooLarge := uploadFailed(results)
+		if !failed {
+			http.Redirect(w, req, "/review", http.StatusSeeOther)
+			return
+		}
+		// Show the uploader which files made it and which did not.
+		d.Results = results
+		if tooLarge {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}
+	err := config.TPL.ExecuteTemplate(w, "upload.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// parsePublishAt reads the value of a datetime-local input in the time zone
+// of the server and returns it in UTC. An empty value means the post is
+// published right away.
+func parsePublishAt(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	publishAt, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return sql.NullTime{Time: publishAt.UTC(), Valid: true}, nil
+}
+
+// uploadFailed reports whether any file failed and whether any of the
+// failures was caused by the size limit.
+func uploadFailed(results []uploadResult) (bool, bool) {
+	var failed, tooLarge bool
+	for _, result := range results {
+		if result.Error != "" {
+			failed = true
+		}
+		if result.TooLarge {
+			tooLarge = true
+		}
+	}
+	return failed, tooLarge
+}
+
+// PostHandler shows a single post. Editors also see its revision history.
+func PostHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Post      Post
+		LoggedIn  bool
+		Revisions []Revision
+		Regions   []Region
+		People    []Person
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	postId, err := strconv.Atoi(req.URL.Path[len("/post/"):])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	post, err := GetPost(postId)
+	if err == sql.ErrNoRows || (err == nil && !loggedIn && !post.Public()) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error loading post. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	regions, err := ListRegions(postId)
+	if err != nil {
+		http.Error(w, "error retrieving people from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Post:     post,
+		LoggedIn: loggedIn,
+		Regions:  regions,
+	}
+	if loggedIn {
+		d.Revisions, err = ListRevisions(postId)
+		if err != nil {
+			http.Error(w, "error retrieving revisions from the database", http.StatusInternalServerError)
+			return
+		}
+		d.People, err = ListPeople(true)
+		if err != nil {
+			http.Error(w, "error retrieving people from the database", http.StatusInternalServerError)
+			return
+		}
+	}
+	err = config.TPL.ExecuteTemplate(w, "post.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// RegionsHandler marks people in a post, or removes a mark, for editors.
+func RegionsHandler(w http.ResponseWriter, req *http.Request) {
+	userId, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	postId, err := strconv.Atoi(req.URL.Path[len("/regions/"):])
+	if err != nil {
+		http.Error(w, "Malformatted post id", http.StatusForbidden)
+		return
+	}
+	switch req.PostFormValue("action") {
+	case "add":
+		region, parseErr := parseRegion(req.PostFormValue("left"), req.PostFormValue("top"), req.PostFormValue("width"), req.PostFormValue("height"))
+		if parseErr != nil {
+			http.Error(w, "Malformatted region: "+parseErr.Error(), http.StatusForbidden)
+			return
+		}
+		_, err = AddRegion(postId, req.PostFormValue("name"), region, *userId)
+	case "delete":
+		regionId, convErr := strconv.Atoi(req.PostFormValue("id"))
+		if convErr != nil {
+			http.Error(w, "Malformatted region id", http.StatusForbidden)
+			return
+		}
+		err = DeleteRegion(regionId, postId)
+	default:
+		http.Error(w, "Unknown action", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error updating people. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, req, fmt.Sprintf("/post/%d", postId), http.StatusSeeOther)
+}
+
+// XMPHandler serves the metadata of a post, including the people marked in
+// it as MWG regions and the negative it was scanned from, as an XMP sidecar
+// file.
+func XMPHandler(w http.ResponseWriter, req *http.Request) {
+	_, loggedIn := users.GetLoginStatus(req)
+	postId, err := strconv.Atoi(strings.TrimSuffix(req.URL.Path[len("/xmp/"):], ".xmp"))
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	post, err := GetPost(postId)
+	if err == sql.ErrNoRows || (err == nil && !loggedIn && !post.Public()) {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error loading post. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	regions, err := ListRegions(postId)
+	if err != nil {
+		http.Error(w, "error retrieving people from the database", http.StatusInternalServerError)
+		return
+	}
+	// the regions are still valid without the size they were drawn on
+	width, height, err := imageDimensions(post.ImageURL)
+	if err != nil {
+		log.Printf("error reading size of %s: %v", post.ImageURL, err)
+	}
+	w.Header().Set("Content-Type", "application/rdf+xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"post-%d.xmp\"", postId))
+	w.Write(postXMP(post, regions, width, height).Marshal())
+}
+
+// PeopleHandler lists the people marked in the photos.
+func PeopleHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		People   []Person
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	people, err := ListPeople(loggedIn)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving people from the database", http.StatusInternalServerError)
 		return
 	}
 	d := data{
-		LoggedIn:    loggedIn,
-		CurrentYear: time.Now().Year(),
+		People:   people,
+		LoggedIn: loggedIn,
 	}
-	err := config.TPL.ExecuteTemplate(w, "upload.gohtml", d)
+	err = config.TPL.ExecuteTemplate(w, "people.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// PersonHandler shows the photos a person is marked in and lets editors
+// rename, describe or delete the person.
+func PersonHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Person   Person
+		Posts    []Post
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	personId, err := strconv.Atoi(req.URL.Path[len("/people/"):])
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method == http.MethodPost {
+		if !loggedIn {
+			http.Redirect(w, req, "/login", http.StatusSeeOther)
+			return
+		}
+		switch req.PostFormValue("action") {
+		case "save":
+			err = UpdatePerson(personId, req.PostFormValue("name"), req.PostFormValue("description"))
+		case "delete":
+			err = DeletePerson(personId)
+		default:
+			http.Error(w, "Unknown action", http.StatusForbidden)
+			return
+		}
+		if err == errNoPersonName || err == errPersonExists {
+			http.Error(w, "Malformatted person: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error updating person. Please try again or contact administrator.", http.StatusInternalServerError)
+			return
+		}
+		if req.PostFormValue("action") == "delete" {
+			http.Redirect(w, req, "/people", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, req, fmt.Sprintf("/people/%d", personId), http.StatusSeeOther)
+		return
+	}
+	person, err := GetPerson(personId, loggedIn)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "error retrieving person from the database", http.StatusInternalServerError)
+		return
+	}
+	postSlice, err := ListPersonPosts(personId, loggedIn)
+	if err != nil {
+		http.Error(w, "error retrieving posts from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Person:   person,
+		Posts:    postSlice,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "person.gohtml", d)
 	if err != nil {
 		http.Error(w, "error templating page", http.StatusInternalServerError)
 	}
 }
 
+// RevertHandler restores a post to the version before or after a revision.
+func RevertHandler(w http.ResponseWriter, req *http.Request) {
+	userId, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	revisionId, err := strconv.Atoi(req.URL.Path[len("/revert/"):])
+	if err != nil {
+		http.Error(w, "Malformatted revision id", http.StatusForbidden)
+		return
+	}
+	revision, err := GetRevision(revisionId)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error loading revision. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	post, err := GetPost(revision.PostId)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error loading post. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	if *userId != post.UserId {
+		http.Error(w, "Permission denied.", http.StatusForbidden)
+		return
+	}
+	err = RevertPost(revision, req.PostFormValue("version") == "before", *userId)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Error reverting post. Please try again or contact administrator.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, req, fmt.Sprintf("/post/%d", revision.PostId), http.StatusSeeOther)
+}
+
 func UpdateHandler(w http.ResponseWriter, req *http.Request) {
 	type data struct {
-		Post        Post
-		LoggedIn    bool
-		CurrentYear int
+		Post     Post
+		Tiles    mapTiles
+		Licenses []license
+		LoggedIn bool
 	}
 	userId, loggedIn := users.GetLoginStatus(req)
 	if !loggedIn {
@@ -110,13 +584,43 @@ func UpdateHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	if req.Method == http.MethodPost {
-		year, err := strconv.Atoi(req.PostFormValue("year"))
+		before := post.snapshot()
+		date, err := parseDateField(req.PostFormValue("date"), req.PostFormValue("year"))
 		if err != nil {
-			http.Error(w, "Malformatted year", http.StatusForbidden)
+			http.Error(w, "Malformatted date: "+err.Error(), http.StatusForbidden)
+			return
 		}
-		post.Year = year
+		post.Date, post.Year = date, date.Year()
 		post.Title = req.PostFormValue("title")
 		post.Description = req.PostFormValue("description")
+		if status := req.PostFormValue("status"); status != "" {
+			if !validStatus(status) {
+				http.Error(w, "Unknown status", http.StatusForbidden)
+				return
+			}
+			post.Status = status
+		}
+		post.PublishAt, err = parsePublishAt(req.PostFormValue("publish_at"))
+		if err != nil {
+			http.Error(w, "Malformatted publication date", http.StatusForbidden)
+			return
+		}
+		post.Latitude, post.Longitude, err = parseLocation(req.PostFormValue("latitude"), req.PostFormValue("longitude"))
+		if err != nil {
+			http.Error(w, "Malformatted location: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		post.PlaceName = strings.TrimSpace(req.PostFormValue("place_name"))
+		post.Provenance, err = parseProvenance(req.PostForm)
+		if err != nil {
+			http.Error(w, "Malformatted provenance: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		post.Rights, err = parseRights(req.PostForm)
+		if err != nil {
+			http.Error(w, "Malformatted rights: "+err.Error(), http.StatusForbidden)
+			return
+		}
 		err = UpdatePost(post)
 		if err != nil {
 			http.Error(w, "Error updating post. Please try again or contact administrator.", http.StatusInternalServerError)
@@ -127,15 +631,19 @@ func UpdateHandler(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "Error updating tags. Please try again or contact administrator.", http.StatusInternalServerError)
 			return
 		}
-		// TODO create a landing page that redirects after 2 seconds !
-		http.Redirect(w, req, fmt.Sprintf("/archive?year=%s", req.PostFormValue("year")), http.StatusSeeOther) // TODO probably want a view handler for individual posts
+		err = recordUpdate(postId, *userId, before)
+		if err != nil {
+			log.Printf("error recording revision of post %d: %v", postId, err)
+		}
+		http.Redirect(w, req, fmt.Sprintf("/post/%d", postId), http.StatusSeeOther)
 		return
 	}
 
 	d := data{
-		Post:        post,
-		LoggedIn:    loggedIn,
-		CurrentYear: time.Now().Year(),
+		Post:     post,
+		Tiles:    tiles(),
+		Licenses: licenses,
+		LoggedIn: loggedIn,
 	}
 	err = config.TPL.ExecuteTemplate(w, "update.gohtml", d)
 	if err != nil {
@@ -143,6 +651,91 @@ func UpdateHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// ReviewHandler lists the user's drafts so title, description, date and tags
+// can be set per photo before publishing them.
+func ReviewHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Posts    []Post
+		LoggedIn bool
+	}
+	userId, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if req.Method == http.MethodPost {
+		err := req.ParseForm()
+		if err != nil {
+			http.Error(w, "Malformatted form", http.StatusBadRequest)
+			return
+		}
+		publish := req.PostFormValue("action") == "publish"
+		for _, id := range req.PostForm["id"] {
+			postId, err := strconv.Atoi(id)
+			if err != nil {
+				http.Error(w, "Malformatted post id", http.StatusForbidden)
+				return
+			}
+			date, err := parseDateField(req.PostFormValue("date-"+id), req.PostFormValue("year-"+id))
+			if err != nil {
+				http.Error(w, "Malformatted date: "+err.Error(), http.StatusForbidden)
+				return
+			}
+			post, err := GetPost(postId)
+			if err == sql.ErrNoRows {
+				http.NotFound(w, req)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Error loading post. Please try again or contact administrator.", http.StatusInternalServerError)
+				return
+			}
+			// only the user's own drafts are up for review
+			if post.UserId != *userId || post.Status != StatusDraft {
+				http.Error(w, "Permission denied.", http.StatusForbidden)
+				return
+			}
+			before := post.snapshot()
+			post.Date, post.Year = date, date.Year()
+			post.Title = req.PostFormValue("title-" + id)
+			post.Description = req.PostFormValue("description-" + id)
+			err = SaveDraft(post, publish)
+			if err != nil {
+				http.Error(w, "Error updating post. Please try again or contact administrator.", http.StatusInternalServerError)
+				return
+			}
+			err = UpdateTags(&postId, parseTags(req.PostFormValue("tags-"+id)))
+			if err != nil {
+				http.Error(w, "Error updating tags. Please try again or contact administrator.", http.StatusInternalServerError)
+				return
+			}
+			err = recordUpdate(postId, *userId, before)
+			if err != nil {
+				log.Printf("error recording revision of post %d: %v", postId, err)
+			}
+		}
+		if publish {
+			http.Redirect(w, req, "/archive", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, req, "/review", http.StatusSeeOther)
+		return
+	}
+	posts, err := ListDrafts(*userId)
+	if err != nil {
+		http.Error(w, "error retrieving drafts from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Posts:    posts,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "review.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
 func DeleteHandler(w http.ResponseWriter, req *http.Request) {
 	userId, loggedIn := users.GetLoginStatus(req)
 	if !loggedIn {
@@ -165,20 +758,206 @@ func DeleteHandler(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, "/", http.StatusForbidden)
 }
 
+// TrashHandler lists deleted posts and restores or purges them on
+// /trash/restore/{id} and /trash/purge/{id}.
+func TrashHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Posts     []Post
+		LoggedIn  bool
+		Retention int
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if req.Method == http.MethodPost {
+		action, id, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/trash/"), "/")
+		postId, err := strconv.Atoi(id)
+		if err != nil {
+			http.Error(w, "requested post id is not valid", http.StatusForbidden)
+			return
+		}
+		switch action {
+		case "restore":
+			err = RestorePost(postId)
+		case "purge":
+			err = PurgePost(postId)
+		default:
+			http.NotFound(w, req)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "requested post could not be "+action+"d", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/trash", http.StatusSeeOther)
+		return
+	}
+	posts, err := ListTrash()
+	if err != nil {
+		http.Error(w, "error retrieving the trash from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Posts:     posts,
+		LoggedIn:  loggedIn,
+		Retention: int(config.TrashRetention.Hours() / 24),
+	}
+	err = config.TPL.ExecuteTemplate(w, "trash.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// TagsHandler lists every tag with its usage and renames, merges or deletes
+// tags on POST.
+func TagsHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Tags     []Tag
+		Types    []string
+		Audit    []TagAudit
+		LoggedIn bool
+	}
+	userId, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if req.Method == http.MethodPost && req.PostFormValue("action") == "cover" {
+		postId, err := strconv.Atoi(req.PostFormValue("post"))
+		if err != nil {
+			http.Error(w, "Malformatted post id", http.StatusForbidden)
+			return
+		}
+		err = SetTagCover(req.PostFormValue("name"), postId, *userId)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error updating tag. Please try again or contact administrator.", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/post/"+strconv.Itoa(postId), http.StatusSeeOther)
+		return
+	}
+	if req.Method == http.MethodPost {
+		tagId, err := strconv.Atoi(req.PostFormValue("id"))
+		if err != nil {
+			http.Error(w, "Malformatted tag id", http.StatusForbidden)
+			return
+		}
+		switch req.PostFormValue("action") {
+		case "rename":
+			err = RenameTag(tagId, req.PostFormValue("name"), *userId)
+		case "merge":
+			targetId, convErr := strconv.Atoi(req.PostFormValue("target"))
+			if convErr != nil {
+				http.Error(w, "Malformatted tag id", http.StatusForbidden)
+				return
+			}
+			err = MergeTags(tagId, targetId, *userId)
+		case "delete":
+			err = DeleteTag(tagId, *userId)
+		case "classify":
+			parentId, convErr := strconv.Atoi(req.PostFormValue("parent"))
+			if convErr != nil {
+				http.Error(w, "Malformatted tag id", http.StatusForbidden)
+				return
+			}
+			err = ClassifyTag(tagId, req.PostFormValue("type"), parentId, *userId)
+		case "curate":
+			displayOrder, orderErr := strconv.Atoi(req.PostFormValue("display_order"))
+			coverPostId, coverErr := strconv.Atoi(req.PostFormValue("cover"))
+			if orderErr != nil || coverErr != nil {
+				http.Error(w, "Malformatted display order or cover", http.StatusForbidden)
+				return
+			}
+			err = CurateTag(tagId, req.PostFormValue("description"), displayOrder, coverPostId, *userId)
+		case "alias":
+			err = AddTagAlias(tagId, req.PostFormValue("alias"), *userId)
+		case "unalias":
+			err = RemoveTagAlias(tagId, req.PostFormValue("alias"), *userId)
+		default:
+			http.Error(w, "Unknown action", http.StatusForbidden)
+			return
+		}
+		if err == errTagExists {
+			http.Error(w, "Malformatted tag: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error updating tag. Please try again or contact administrator.", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, req, "/admin/tags", http.StatusSeeOther)
+		return
+	}
+	tags, err := ListTags()
+	if err != nil {
+		http.Error(w, "error retrieving tags from the database", http.StatusInternalServerError)
+		return
+	}
+	audit, err := ListTagAudit(50)
+	if err != nil {
+		http.Error(w, "error retrieving tag audit from the database", http.StatusInternalServerError)
+		return
+	}
+	d := data{
+		Tags:     tags,
+		Types:    tagTypes,
+		Audit:    audit,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "tags.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
+
+// TagSuggestHandler returns existing tags matching the q parameter as JSON,
+// used by the tag fields of the upload and update forms.
+func TagSuggestHandler(w http.ResponseWriter, req *http.Request) {
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	suggestions, err := SuggestTags(req.URL.Query().Get("q"), 10)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving tags from the database", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(suggestions)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func TagRepHandler(w http.ResponseWriter, req *http.Request) {
 	type data struct {
-		Posts    []Post
+		Groups   []tagGroup
+		Featured *Post
 		LoggedIn bool
 	}
 	_, loggedIn := users.GetLoginStatus(req)
-	posts, err := listTagReps()
+	reps, err := listTagReps(loggedIn)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	d := data{
-		Posts:    posts,
+		Groups:   groupTagReps(reps),
 		LoggedIn: loggedIn,
 	}
+	featured, err := FeaturedPost(time.Now())
+	if err == nil {
+		d.Featured = &featured
+	} else if err != sql.ErrNoRows {
+		log.Printf("error choosing the photo of the day: %v", err)
+	}
 	err = config.TPL.ExecuteTemplate(w, "index.gohtml", d)
 	if err != nil {
 		http.Error(w, "error templating page", http.StatusInternalServerError)
@@ -0,0 +1,190 @@
+package posts
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"project/server/config"
+	"project/server/derivatives"
+	"project/server/downloads"
+	"project/server/imagemeta"
+	"project/server/objects"
+	"project/server/users"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go"
+)
+
+// getPostByObject returns a post showing the object. Visitors only find
+// public posts, editors also find drafts and the posts in the trash.
+func getPostByObject(objectName string, showAll bool) (Post, error) {
+	condition := "TRUE"
+	if !showAll {
+		condition = VisibleCondition(false)
+	}
+	row := config.DB.QueryRow(`
+		SELECT `+postColumns+`, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE p.minio_url = $1 AND `+condition+`
+		GROUP BY p.id
+		ORDER BY p.id
+		LIMIT 1;
+	`, objectName)
+	post, err := scanPost(row)
+	if err != nil {
+		return post, err
+	}
+	post.Rights, err = getRights(post.Id)
+	return post, err
+}
+
+// ImageHandler serves the image of a post. Editors get the original,
+// visitors only the reduced and watermarked derivative; originals reach them
+// through signed download links.
+func ImageHandler(w http.ResponseWriter, req *http.Request) {
+	_, loggedIn := users.GetLoginStatus(req)
+	objectName := req.URL.Path[len("/blob/"):]
+	post, err := getPostByObject(objectName, loggedIn)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving post from the database", http.StatusInternalServerError)
+		return
+	}
+	serveImage(w, post, !loggedIn)
+}
+
+// DownloadHandler hands out the original image of a post as an attachment to
+// whoever holds a signed link to it.
+func DownloadHandler(w http.ResponseWriter, req *http.Request) {
+	postId, err := strconv.Atoi(req.URL.Path[len("/download/"):])
+	if err != nil {
+		http.Error(w, "Malformatted post id", http.StatusForbidden)
+		return
+	}
+	_, err = downloads.Verify(postId, req.URL.Query())
+	if err == downloads.ErrExpired {
+		http.Error(w, "This download link has expired, please ask for a new one.", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "This download link is invalid.", http.StatusForbidden)
+		return
+	}
+	post, err := GetPost(postId)
+	if err == sql.ErrNoRows || post.DeletedAt.Valid {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving post from the database", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(post.ImageURL)))
+	serveImage(w, post, false)
+}
+
+// serveImage streams the original image of a post, or its derivative. JPEGs
+// get the title and rights of the post embedded as XMP when it fits, so they
+// stay with the photo when it is saved.
+func serveImage(w http.ResponseWriter, post Post, derivative bool) {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var object io.ReadCloser
+	contentType := mime.TypeByExtension(filepath.Ext(post.ImageURL))
+	if derivative {
+		object, err = derivatives.Open(minioClient, post.ImageURL)
+		contentType = "image/jpeg"
+	} else {
+		object, err = minioClient.GetObject(objects.Bucket, post.ImageURL, minio.GetObjectOptions{})
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving image", http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	if contentType == "image/jpeg" {
+		// a packet or JPEG that can not be embedded serves the image as it is
+		err = imagemeta.EmbedXMP(w, object, rightsXMP(post).Marshal())
+	} else {
+		_, err = io.Copy(w, object)
+	}
+	// the image may be partly written, so the failure can only be logged
+	if err != nil {
+		log.Printf("error serving %s: %v", post.ImageURL, err)
+	}
+}
+
+// DownloadLinkHandler lets editors sign a link to the original of a post, to
+// hand to someone who may use it.
+func DownloadLinkHandler(w http.ResponseWriter, req *http.Request) {
+	type data struct {
+		Post     Post
+		URL      string
+		Expires  time.Time
+		LoggedIn bool
+	}
+	_, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Redirect(w, req, "/login", http.StatusSeeOther)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	postId, err := strconv.Atoi(req.URL.Path[len("/downloads/"):])
+	if err != nil {
+		http.Error(w, "Malformatted post id", http.StatusForbidden)
+		return
+	}
+	validFor := config.DownloadLinkValidity
+	if value := strings.TrimSpace(req.PostFormValue("hours")); value != "" {
+		hours, convErr := strconv.Atoi(value)
+		if convErr != nil || hours <= 0 {
+			http.Error(w, "Malformatted number of hours", http.StatusForbidden)
+			return
+		}
+		validFor = time.Duration(hours) * time.Hour
+	}
+	post, err := GetPost(postId)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error retrieving post from the database", http.StatusInternalServerError)
+		return
+	}
+	link := downloads.New(post.Id, validFor)
+	d := data{
+		Post:     post,
+		URL:      link.URL(req),
+		Expires:  link.Expires,
+		LoggedIn: loggedIn,
+	}
+	err = config.TPL.ExecuteTemplate(w, "downloadlink.gohtml", d)
+	if err != nil {
+		http.Error(w, "error templating page", http.StatusInternalServerError)
+	}
+}
@@ -1,8 +1,11 @@
 package posts
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha1"
 	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -11,6 +14,9 @@ import (
 	"net/http"
 	"net/url"
 	"project/server/config"
+	"project/server/derivatives"
+	"project/server/imagemeta"
+	"project/server/objects"
 	"project/server/users"
 	"strconv"
 	"strings"
@@ -18,6 +24,7 @@ import (
 
 	"github.com/lib/pq"
 	"github.com/minio/minio-go"
+	uuid "github.com/satori/go.uuid"
 )
 
 type Post struct {
@@ -30,34 +37,87 @@ type Post struct {
 	UserId      int
 	Title       string
 	Description string
+	Status      string
+	PublishAt   sql.NullTime
+	DeletedAt   sql.NullTime
+	DeletedBy   sql.NullInt64
 	Tags        []string
+	// Date is when the photo was taken; Year follows from it.
+	Date FuzzyDate
+	// Latitude and Longitude are where the photo was taken, both or neither
+	// set. PlaceName is how people know the spot.
+	Latitude  sql.NullFloat64
+	Longitude sql.NullFloat64
+	PlaceName string
+	// Provenance and Rights are only loaded by GetPost.
+	Provenance Provenance
+	Rights     Rights
 }
 
-func CreatePost(minioUrl string, year int, userId int) (*int, error) {
-	var postId int
-	err := config.DB.QueryRow(`
-		INSERT INTO posts (MINIO_URL, YEAR, USER_ID) VALUES ($1, $2, $3) RETURNING ID;`,
-		minioUrl, year, userId).Scan(&postId)
-	if err != nil {
-		return nil, err
+// Uploaded posts start out as drafts and only show up in the archive once
+// they are published. Hidden posts stay in the archive for editors only.
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+	StatusHidden    = "hidden"
+)
+
+// publicCondition selects the posts anonymous visitors may see: published and
+// not scheduled for a later date. Deleted posts are filtered out separately
+// since editors only see those in the trash.
+const publicCondition = `p.status = 'published' AND (p.publish_at IS NULL OR p.publish_at <= NOW())`
+
+const notDeletedCondition = `p.deleted_at IS NULL`
+
+// VisibleCondition is the condition on posts aliased p for what a visitor
+// may see. Editors see every post that is not in the trash.
+func VisibleCondition(showAll bool) string {
+	if showAll {
+		return notDeletedCondition
 	}
-	return &postId, nil
+	return notDeletedCondition + " AND " + publicCondition
 }
 
-func GetPost(postId int) (Post, error) {
+// Public reports whether anonymous visitors may see the post.
+func (post Post) Public() bool {
+	return post.Status == StatusPublished && !post.Scheduled() && !post.DeletedAt.Valid
+}
+
+func validStatus(status string) bool {
+	return status == StatusDraft || status == StatusPublished || status == StatusHidden
+}
+
+// Scheduled reports whether a published post waits for its publication date.
+func (post Post) Scheduled() bool {
+	return post.Status == StatusPublished && post.PublishAt.Valid && post.PublishAt.Time.After(time.Now())
+}
+
+// postColumns lists the posts columns in the order scanPost expects them,
+// followed by the aggregated tag names.
+const postColumns = `p.id, p.minio_url, p.year, p.created_at, p.updated_at, p.edited, p.user_id, p.title, p.description, p.status, p.publish_at, p.deleted_at, p.deleted_by, p.date_precision, p.date_start, p.date_end, p.latitude, p.longitude, p.place_name`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// execer runs the statements that create a post, on config.DB or in a
+// transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func scanPost(row scanner) (Post, error) {
 	post := Post{}
 	var tags []sql.NullString
-	err := config.DB.QueryRow(`
-		SELECT posts.*, array_agg(tags.name) AS tags
-		FROM posts
-		LEFT JOIN tagmap ON posts.id = tagmap.post_id
-		LEFT JOIN tags ON tagmap.tag_id = tags.id
-		WHERE posts.id=$1
-		GROUP BY posts.id;
-	`, postId).Scan(&post.Id, &post.ImageURL, &post.Year, &post.CreatedAt, &post.UpdatedAt, &post.Edited, &post.UserId, &post.Title, &post.Description, pq.Array(&tags))
+	var dateStart, dateEnd sql.NullTime
+	err := row.Scan(&post.Id, &post.ImageURL, &post.Year, &post.CreatedAt, &post.UpdatedAt, &post.Edited, &post.UserId, &post.Title, &post.Description, &post.Status, &post.PublishAt, &post.DeletedAt, &post.DeletedBy, &post.Date.Precision, &dateStart, &dateEnd, &post.Latitude, &post.Longitude, &post.PlaceName, pq.Array(&tags))
 	if err != nil {
 		return post, err
 	}
+	post.Date.Start, post.Date.End = dateStart.Time, dateEnd.Time
+	// shown and edited in the time zone of the server, like it was entered
+	post.PublishAt.Time = post.PublishAt.Time.Local()
 	for _, nullString := range tags {
 		if !nullString.Valid {
 			continue
@@ -67,35 +127,325 @@ func GetPost(postId int) (Post, error) {
 	return post, nil
 }
 
+func CreatePost(minioUrl string, year int, userId int) (*int, error) {
+	var postId int
+	start, end := dateOfYear(year).bounds()
+	err := config.DB.QueryRow(`
+		INSERT INTO posts (MINIO_URL, YEAR, USER_ID, DATE_START, DATE_END) VALUES ($1, $2, $3, $4, $5) RETURNING ID;`,
+		minioUrl, year, userId, start, end).Scan(&postId)
+	if err != nil {
+		return nil, err
+	}
+	return &postId, nil
+}
+
+func CreateDraft(minioUrl string, date FuzzyDate, userId int) (*int, error) {
+	return insertDraft(config.DB, minioUrl, date, userId)
+}
+
+func insertDraft(db execer, minioUrl string, date FuzzyDate, userId int) (*int, error) {
+	var postId int
+	start, end := date.bounds()
+	err := db.QueryRow(`
+		INSERT INTO posts (MINIO_URL, YEAR, USER_ID, STATUS, DATE_PRECISION, DATE_START, DATE_END)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ID;`,
+		minioUrl, date.Year(), userId, StatusDraft, date.Precision, start, end).Scan(&postId)
+	if err != nil {
+		return nil, err
+	}
+	return &postId, nil
+}
+
+func GetPost(postId int) (Post, error) {
+	row := config.DB.QueryRow(`
+		SELECT `+postColumns+`, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE p.id=$1
+		GROUP BY p.id;
+	`, postId)
+	post, err := scanPost(row)
+	if err != nil {
+		return post, err
+	}
+	post.Provenance, err = getProvenance(postId)
+	if err != nil {
+		return post, err
+	}
+	post.Rights, err = getRights(postId)
+	return post, err
+}
+
 func UpdatePost(post Post) error {
-	_, err := config.DB.Exec(`
+	post = post.dated()
+	start, end := post.Date.bounds()
+	result, err := config.DB.Exec(`
 		UPDATE posts 
 		SET UPDATED_AT=NOW(), 
 			EDITED=TRUE, 
 			TITLE=$1, 
 			DESCRIPTION=$2,
-			YEAR=$3 
-		WHERE ID=$4 and USER_ID=$5;
+			YEAR=$3,
+			STATUS=$4,
+			PUBLISH_AT=$5,
+			DATE_PRECISION=$6,
+			DATE_START=$7,
+			DATE_END=$8,
+			LATITUDE=$9,
+			LONGITUDE=$10,
+			PLACE_NAME=$11
+		WHERE ID=$12 and USER_ID=$13;
+		`,
+		post.Title, post.Description, post.Year, post.Status, post.PublishAt, post.Date.Precision, start, end, post.Latitude, post.Longitude, post.PlaceName, post.Id, post.UserId)
+	if err != nil {
+		return err
+	}
+	// only the owner of the post may change its provenance and rights
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return err
+	}
+	err = saveProvenance(post.Id, post.Provenance)
+	if err != nil {
+		return err
+	}
+	return saveRights(post.Id, post.Rights)
+}
+
+// dated fills in a missing date from the year, for callers that only set
+// the year.
+func (post Post) dated() Post {
+	if post.Date.Year() != post.Year {
+		post.Date = dateOfYear(post.Year)
+	}
+	return post
+}
+
+var errNotDraft = errors.New("post does not exist or is not a draft of the user")
+
+// SaveDraft stores the metadata of one of the user's drafts and publishes it
+// when publish is set.
+func SaveDraft(post Post, publish bool) error {
+	status := StatusDraft
+	if publish {
+		status = StatusPublished
+	}
+	post = post.dated()
+	start, end := post.Date.bounds()
+	result, err := config.DB.Exec(`
+		UPDATE posts
+		SET UPDATED_AT=NOW(),
+			TITLE=$1,
+			DESCRIPTION=$2,
+			YEAR=$3,
+			STATUS=$4,
+			DATE_PRECISION=$5,
+			DATE_START=$6,
+			DATE_END=$7
+		WHERE ID=$8 AND USER_ID=$9 AND STATUS=$10;
 		`,
-		post.Title, post.Description, post.Year, post.Id, post.UserId)
+		post.Title, post.Description, post.Year, status, post.Date.Precision, start, end, post.Id, post.UserId, StatusDraft)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err == nil && rows == 0 {
+		err = errNotDraft
+	}
 	return err
 }
 
+func ListDrafts(userId int) ([]Post, error) {
+	postSlice := make([]Post, 0)
+	rows, err := config.DB.Query(`
+		SELECT `+postColumns+`, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE p.status = $1 AND p.user_id = $2 AND `+notDeletedCondition+`
+		GROUP BY p.id
+		ORDER BY p.id;
+		`, StatusDraft, userId)
+	if err != nil {
+		return postSlice, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return postSlice, err
+		}
+		postSlice = append(postSlice, post)
+	}
+	return postSlice, rows.Err()
+}
+
+// DeletePost moves a post to the trash, from where it can be restored until
+// it is purged.
 func DeletePost(postId int, userId int) error {
-	_, err := config.DB.Exec("DELETE FROM tagmap WHERE post_id=$1;", postId)
+	_, err := config.DB.Exec(`
+		UPDATE posts
+		SET DELETED_AT=NOW(), DELETED_BY=$2
+		WHERE ID=$1 AND USER_ID=$2 AND DELETED_AT IS NULL;
+		`, postId, userId)
+	return err
+}
+
+func RestorePost(postId int) error {
+	_, err := config.DB.Exec("UPDATE posts SET DELETED_AT=NULL, DELETED_BY=NULL WHERE ID=$1;", postId)
+	return err
+}
+
+// PurgePost permanently removes a post from the trash along with its object,
+// unless another post shows the same object.
+func PurgePost(postId int) error {
+	var minioUrl string
+	err := config.DB.QueryRow("SELECT minio_url FROM posts WHERE ID=$1 AND DELETED_AT IS NOT NULL;", postId).Scan(&minioUrl)
 	if err != nil {
 		return err
 	}
-	_, err = config.DB.Exec("DELETE FROM posts WHERE ID=$1 AND USER_ID=$2;", postId, userId)
-	return err
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM tagmap WHERE post_id=$1;", postId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM posts WHERE ID=$1;", postId)
+	if err != nil {
+		return err
+	}
+	// objects are named by their hash, so uploading a photo twice shares one
+	var shared bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE minio_url=$1);", minioUrl).Scan(&shared)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil || shared {
+		return err
+	}
+	return objects.Remove(minioUrl)
+}
+
+func ListTrash() ([]Post, error) {
+	postSlice := make([]Post, 0)
+	rows, err := config.DB.Query(`
+		SELECT ` + postColumns + `, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE p.deleted_at IS NOT NULL
+		GROUP BY p.id
+		ORDER BY p.deleted_at DESC;
+		`)
+	if err != nil {
+		return postSlice, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return postSlice, err
+		}
+		postSlice = append(postSlice, post)
+	}
+	return postSlice, rows.Err()
+}
+
+// ListPostsById returns the posts with the given ids in that order, leaving
+// out deleted posts and, unless showAll is set, posts that are not public.
+func ListPostsById(ids []int, showAll bool) ([]Post, error) {
+	postSlice := make([]Post, 0)
+	postIds := make([]int64, len(ids))
+	for i, id := range ids {
+		postIds[i] = int64(id)
+	}
+	rows, err := config.DB.Query(`
+		SELECT `+postColumns+`, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE p.id = ANY($1::integer[]) AND `+VisibleCondition(showAll)+`
+		GROUP BY p.id
+		ORDER BY array_position($1::integer[], p.id);
+		`, pq.Array(postIds))
+	if err != nil {
+		return postSlice, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return postSlice, err
+		}
+		postSlice = append(postSlice, post)
+	}
+	return postSlice, rows.Err()
+}
+
+// purgeExpired purges every post that has been in the trash for longer than
+// retention and returns how many were purged.
+func purgeExpired(retention time.Duration) (int, error) {
+	var purged int
+	rows, err := config.DB.Query("SELECT id FROM posts WHERE deleted_at < $1;", time.Now().Add(-retention))
+	if err != nil {
+		return purged, err
+	}
+	var postIds []int
+	for rows.Next() {
+		var postId int
+		if err := rows.Scan(&postId); err != nil {
+			rows.Close()
+			return purged, err
+		}
+		postIds = append(postIds, postId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return purged, err
+	}
+	for _, postId := range postIds {
+		if err := PurgePost(postId); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// PurgeTrashPeriodically empties the trash of expired posts every hour.
+func PurgeTrashPeriodically(retention time.Duration) {
+	for {
+		purged, err := purgeExpired(retention)
+		if err != nil {
+			log.Printf("error purging the trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d posts from the trash", purged)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// archiveFilter narrows down the posts listed in the archive.
+type archiveFilter struct {
+	Year int
+	// Decade is the first year of a decade, e.g. 1960.
+	Decade int
+	Tag    string
+	// Search matches words against titles, descriptions, tags and aliases.
+	Search string
+	// ShowAll includes drafts, hidden and scheduled posts for editors.
+	ShowAll bool
 }
 
-func ListPosts(limit, offset int, year int, tag string) ([]Post, bool, bool, error) {
+func ListPosts(limit, offset int, filter archiveFilter) ([]Post, bool, bool, error) {
 	var first bool
 	var last bool
-	var tags []sql.NullString
 	postSlice := make([]Post, 0)
-	rows, err := queryArchive(tag, year, limit, offset)
+	rows, err := queryArchive(filter, limit, offset)
 	if err != nil {
 		log.Println(err)
 		return postSlice, false, false, err
@@ -103,17 +453,10 @@ func ListPosts(limit, offset int, year int, tag string) ([]Post, bool, bool, err
 	defer rows.Close()
 
 	for rows.Next() {
-		post := Post{}
-		err := rows.Scan(&post.Id, &post.ImageURL, &post.Year, &post.CreatedAt, &post.UpdatedAt, &post.Edited, &post.UserId, &post.Title, &post.Description, pq.Array(&tags))
+		post, err := scanPost(rows)
 		if err != nil {
 			return postSlice, false, false, err
 		}
-		for _, nullString := range tags {
-			if !nullString.Valid {
-				continue
-			}
-			post.Tags = append(post.Tags, nullString.String)
-		}
 		postSlice = append(postSlice, post)
 	}
 	err = rows.Err()
@@ -132,62 +475,46 @@ func ListPosts(limit, offset int, year int, tag string) ([]Post, bool, bool, err
 	return postSlice, first, last, nil
 }
 
-func queryArchive(tag string, year, limit, offset int) (*sql.Rows, error) {
-	var rows *sql.Rows
-	var err error
-	if year == 0 {
-		if tag == "" {
-			rows, err = config.DB.Query(`
-				SELECT p.*, array_agg(t.name) AS tags
-				FROM posts p
-				LEFT JOIN tagmap tm ON p.id = tm.post_id
-				LEFT JOIN tags t ON tm.tag_id = t.id
-				GROUP BY p.id
-				ORDER BY p.updated_at DESC
-				LIMIT $1
-				OFFSET $2;
-				`, limit+1, offset)
-		} else {
-			rows, err = config.DB.Query(`
-				SELECT p.*, array_agg(t.name) AS tags
-				FROM posts p
-				LEFT JOIN tagmap tm ON p.id = tm.post_id
-				LEFT JOIN tags t ON tm.tag_id = t.id
-				GROUP BY p.id
-				HAVING count(CASE WHEN t.name = $1 THEN 1 ELSE NULL END) >= 1
-				ORDER BY p.updated_at DESC
-				LIMIT $2
-				OFFSET $3;
-				`, tag, limit+1, offset)
-		}
-	} else {
-		if tag == "" {
-			rows, err = config.DB.Query(`
-				SELECT p.*, array_agg(t.name) AS tags
-				FROM posts p
-				LEFT JOIN tagmap tm ON p.id = tm.post_id
-				LEFT JOIN tags t ON tm.tag_id = t.id
-				WHERE p.year = $1
-				GROUP BY p.id
-				ORDER BY p.updated_at DESC
-				LIMIT $2
-				OFFSET $3;
-				`, year, limit+1, offset)
-		} else {
-			rows, err = config.DB.Query(`
-				SELECT p.*, array_agg(t.name) AS tags
-				FROM posts p
-				LEFT JOIN tagmap tm ON p.id = tm.post_id
-				LEFT JOIN tags t ON tm.tag_id = t.id
-				WHERE p.year = $1
-				GROUP BY p.id
-				HAVING count(CASE WHEN t.name = $2 THEN 1 ELSE NULL END) >= 1
-				ORDER BY p.updated_at DESC
-				LIMIT $3
-				OFFSET $4;
-				`, year, tag, limit+1, offset)
-		}
-	}
+func queryArchive(filter archiveFilter, limit, offset int) (*sql.Rows, error) {
+	conditions := []string{notDeletedCondition}
+	var args []any
+	if !filter.ShowAll {
+		conditions = append(conditions, publicCondition)
+	}
+	// browsing a period lists it in chronological order, the rest of the
+	// archive shows the most recently updated posts first
+	order := "p.updated_at DESC"
+	if filter.Year != 0 {
+		args = append(args, filter.Year)
+		conditions = append(conditions, fmt.Sprintf("p.year = $%d", len(args)))
+		order = chronologicalOrder
+	}
+	if filter.Decade != 0 {
+		args = append(args, filter.Decade)
+		conditions = append(conditions, fmt.Sprintf("p.year >= $%[1]d AND p.year < $%[1]d + 10", len(args)))
+		order = chronologicalOrder
+	}
+	if filter.Tag != "" {
+		args = append(args, pq.Array([]string{filter.Tag}))
+		conditions = append(conditions, tagTreeCondition(len(args)))
+	}
+	for _, word := range strings.Fields(strings.ToLower(filter.Search)) {
+		args = append(args, "%"+likeEscaper.Replace(word)+"%", word)
+		conditions = append(conditions, searchCondition(len(args)-1))
+	}
+	where := "WHERE " + strings.Join(conditions, " AND ")
+	args = append(args, limit+1, offset)
+	rows, err := config.DB.Query(fmt.Sprintf(`
+		SELECT `+postColumns+`, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		%s
+		GROUP BY p.id
+		ORDER BY %s
+		LIMIT $%d
+		OFFSET $%d;
+		`, where, order, len(args)-1, len(args)), args...)
 	if err != nil {
 		log.Printf("Error querying the archive: %v\n", err)
 	}
@@ -212,15 +539,23 @@ func parseTags(tags string) []string {
 }
 
 func createTags(postId *int, tags []string) error {
+	return insertTags(config.DB, postId, tags)
+}
+
+func insertTags(db execer, postId *int, tags []string) error {
 	var tagId int
 	for _, tag := range cleanTags(tags) {
-		err := config.DB.QueryRow(`
+		tag, err := resolveAlias(tag)
+		if err != nil {
+			return err
+		}
+		err = db.QueryRow(`
 			INSERT INTO tags (name) VALUES ($1) ON CONFLICT (name) DO UPDATE SET name = $1 RETURNING id;
 			`, tag).Scan(&tagId)
 		if err != nil {
 			return err
 		}
-		_, err = config.DB.Exec(`
+		_, err = db.Exec(`
 			INSERT INTO tagmap (post_id, tag_id)
 			VALUES ($1, $2)
 			ON CONFLICT (post_id, tag_id)
@@ -243,31 +578,31 @@ func cleanTags(tags []string) []string {
 	return cleanTags
 }
 
-func listYears(tags []string) ([]int, error) {
+func listYears(tags []string, showAll bool) ([]int, error) {
 	var year int
 	var years []int
-	var filter string
+	var args []any
 	var rows *sql.Rows
 
 	if len(tags) == 0 {
 		return nil, fmt.Errorf("tags are empty")
-	} else if len(tags) == 1 && tags[0] == "" {
-		filter = ""
-	} else {
-		filter = `
-			JOIN tagmap ON posts.id = tagmap.post_id 
-			WHERE tagmap.tag_id IN (
-				SELECT id FROM tags WHERE name IN ('` + strings.Join(tags, "','") + `')
-			)
-		`
 	}
+	conditions := []string{notDeletedCondition}
+	if !showAll {
+		conditions = append(conditions, publicCondition)
+	}
+	if len(tags) > 1 || tags[0] != "" {
+		args = append(args, pq.Array(tags))
+		conditions = append(conditions, tagTreeCondition(len(args)))
+	}
+	where := "WHERE " + strings.Join(conditions, " AND ")
 
 	query := fmt.Sprintf(`
-        SELECT DISTINCT posts.year 
-        FROM posts 
+        SELECT DISTINCT p.year 
+        FROM posts p
 		%s
-        ORDER BY posts.year ASC;
-    `, filter)
+        ORDER BY p.year ASC;
+    `, where)
 	// Prepare the query
 	stmt, err := config.DB.Prepare(query)
 	if err != nil {
@@ -276,7 +611,7 @@ func listYears(tags []string) ([]int, error) {
 	defer stmt.Close()
 
 	// Execute the query
-	rows, err = stmt.Query()
+	rows, err = stmt.Query(args...)
 	if err != nil {
 		return nil, err
 	}
@@ -298,54 +633,55 @@ func listYears(tags []string) ([]int, error) {
 	return years, nil
 }
 
-func listTagReps() ([]Post, error) {
-	var tags []string
-	postSlice := make([]Post, 0)
+// listTagReps returns a tile for every tag with a visible post, showing the
+// cover pinned by an editor or else the latest post of the tag.
+func listTagReps(showAll bool) ([]tagRep, error) {
+	reps := make([]tagRep, 0)
 	rows, err := config.DB.Query(`
-		SELECT
-			MAX(posts.id) AS post_id,
-			posts.minio_url AS file,
-			ARRAY_AGG(tags.name) AS tags
-		FROM
-			(
-				SELECT tag_id, MAX(post_id) AS last_post_id
-				FROM tagmap
-				GROUP BY tag_id
-			) AS latest_post
-			JOIN tags ON tags.id = latest_post.tag_id
-			JOIN posts ON posts.id = latest_post.last_post_id
-		GROUP BY
-			posts.minio_url
-		ORDER BY
-			tags, MAX(posts.created_at) DESC;
-		`)
+		SELECT t.name, t.type, t.description, p.id, p.minio_url
+		FROM tags t
+		JOIN LATERAL (
+			SELECT p.id, p.minio_url
+			FROM posts p
+			WHERE (p.id = t.cover_post_id OR p.id IN (SELECT post_id FROM tagmap WHERE tag_id = t.id))
+			AND `+notDeletedCondition+` AND ($1 OR (`+publicCondition+`))
+			ORDER BY p.id = t.cover_post_id DESC NULLS LAST, p.id DESC
+			LIMIT 1
+		) p ON TRUE
+		ORDER BY t.display_order, t.name;
+		`, showAll)
 	if err != nil {
 		log.Println(err)
-		return postSlice, err
+		return reps, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		post := Post{}
-		err := rows.Scan(&post.Id, &post.ImageURL, pq.Array(&tags))
+		rep := tagRep{}
+		err := rows.Scan(&rep.Name, &rep.Type, &rep.Description, &rep.PostId, &rep.ImageURL)
 		if err != nil {
-			return postSlice, err
+			return reps, err
 		}
-		post.Tags = tags
-		postSlice = append(postSlice, post)
+		reps = append(reps, rep)
 	}
 	err = rows.Err()
-	return postSlice, err
+	return reps, err
 }
 
-func createProperties(limit, offset, year int, tag string) (template.URL, template.URL, template.URL) {
+func createProperties(limit, offset int, filter archiveFilter) (template.URL, template.URL, template.URL) {
 	prevOffset, nextOffset := navigateOffsets(limit, offset)
 
 	var filterProperties string
-	if year > 0 {
-		filterProperties = "&year=" + strconv.Itoa(year)
+	if filter.Year > 0 {
+		filterProperties = "&year=" + strconv.Itoa(filter.Year)
+	}
+	if filter.Decade > 0 {
+		filterProperties += "&decade=" + strconv.Itoa(filter.Decade)
+	}
+	if filter.Tag != "" {
+		filterProperties += "&tag=" + url.QueryEscape(filter.Tag)
 	}
-	if tag != "" {
-		filterProperties += "&tag=" + url.QueryEscape(tag)
+	if filter.Search != "" {
+		filterProperties += "&q=" + url.QueryEscape(filter.Search)
 	}
 
 	properties := template.URL("limit=" + strconv.Itoa(limit) + "&offset=" + strconv.Itoa(offset) + filterProperties)
@@ -435,54 +771,199 @@ func abs(x int) int {
 	return x
 }
 
-func storeFiles(req *http.Request) error {
+// allowedContentTypes maps the sniffed content types we accept for uploads
+// to the extension used in the object name.
+var allowedContentTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+	"image/tiff": "tif",
+}
+
+// errFileTooLarge is returned when a single file exceeds config.MaxFileSize.
+var errFileTooLarge = errors.New("file exceeds the maximum upload size")
+
+// maxFieldSize limits the size of the regular form values in an upload.
+const maxFieldSize = 1 << 20
+
+type uploadResult struct {
+	Filename string
+	PostId   int
+	Error    string
+	TooLarge bool
+}
+
+// storeFiles streams every file in the multipart upload straight into the
+// blob store. The date (or year) and tags fields have to precede the files in
+// the form.
+func storeFiles(w http.ResponseWriter, req *http.Request) ([]uploadResult, error) {
 	userId, ok := users.GetLoginStatus(req)
 	if !ok {
-		return fmt.Errorf("request is unauthenticated")
+		return nil, fmt.Errorf("request is unauthenticated")
 	}
-	err := req.ParseMultipartForm(10000000) // max 10 megabytes
+	req.Body = http.MaxBytesReader(w, req.Body, config.MaxRequestSize)
+	reader, err := req.MultipartReader()
 	if err != nil {
-		return fmt.Errorf("error parsing form data: %v", err)
+		return nil, fmt.Errorf("error parsing form data: %w", err)
 	}
 	minioClient, err := config.NewMinIO()
 	if err != nil {
-		return fmt.Errorf("error creating minio client: %v", err)
-	}
-	year, err := strconv.Atoi(req.PostFormValue("year"))
-	if err != nil {
-		return fmt.Errorf("'year' is not an integer: %v", err)
-	}
-	tags := parseTags(req.PostFormValue("tags"))
-	fileHeaders := req.MultipartForm.File["file"]
-	for _, fileHeader := range fileHeaders {
-		src, err := fileHeader.Open()
+		return nil, fmt.Errorf("error creating minio client: %v", err)
+	}
+	fields := url.Values{}
+	results := make([]uploadResult, 0)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return fmt.Errorf("error opening file: %v", err)
+			return results, fmt.Errorf("error parsing form data: %w", err)
 		}
-		defer src.Close()
-		hashSum := computeHashSum(src)
-		ext := strings.Split(fileHeader.Filename, ".")[1]
-		objectName := req.PostFormValue("year") + "/" + fmt.Sprintf("%x", hashSum) + "." + ext
-		_, err = minioClient.PutObject("download", objectName, src, fileHeader.Size, minio.PutObjectOptions{ContentType: "image/jpeg"})
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+			part.Close()
+			if err != nil {
+				return results, fmt.Errorf("error parsing form data: %w", err)
+			}
+			fields.Add(part.FormName(), string(value))
+			continue
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		date, err := parseDateField(fields.Get("date"), fields.Get("year"))
 		if err != nil {
-			return fmt.Errorf("error storing file on S3: %v", err)
+			part.Close()
+			return results, fmt.Errorf("date is invalid or was sent after the files: %v", err)
+		}
+		result := uploadResult{Filename: part.FileName()}
+		postId, err := storeFile(minioClient, part, date, parseTags(fields.Get("tags")), *userId)
+		part.Close()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return results, err
 		}
-		minioUrl := objectName
-		postId, err := CreatePost(minioUrl, year, *userId)
 		if err != nil {
-			return fmt.Errorf("error inserting post in database: %v", err)
+			log.Printf("error storing %q: %v", result.Filename, err)
+			result.Error = err.Error()
+			result.TooLarge = errors.Is(err, errFileTooLarge)
+		} else {
+			result.PostId = *postId
 		}
-		err = createTags(postId, tags)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// storeFile uploads file under a temporary name while hashing it and hands
+// the result to createPostFromObject.
+func storeFile(minioClient *minio.Client, file io.Reader, date FuzzyDate, tags []string, userId int) (*int, error) {
+	src := bufio.NewReaderSize(file, imagemeta.HeaderSize)
+	contentType, err := detectFormat(src)
+	if err != nil {
+		return nil, err
+	}
+	location := exifLocation(src)
+	h := sha1.New()
+	limited := &maxSizeReader{r: src, max: config.MaxFileSize}
+	tmpName := "uploads/" + uuid.NewV4().String()
+	_, err = objects.PutStream(minioClient, tmpName, io.TeeReader(limited, h), contentType)
+	if err != nil {
+		return nil, fmt.Errorf("error storing file on S3: %w", err)
+	}
+	return createPostFromObject(minioClient, tmpName, contentType, fmt.Sprintf("%x", h.Sum(nil)), date, location, tags, userId)
+}
+
+// createPostFromObject moves a fully uploaded object to its content addressed
+// name and creates a tagged draft post for it, placed at the location from its
+// EXIF data if it has one, in one transaction. This is where both the form
+// upload and the resumable upload end up.
+func createPostFromObject(minioClient *minio.Client, tmpName, contentType, hashSum string, date FuzzyDate, location *imagemeta.Location, tags []string, userId int) (*int, error) {
+	objectName := createObjectName(date.Year(), hashSum, allowedContentTypes[contentType])
+	err := objects.Rename(minioClient, tmpName, objectName, contentType)
+	if err != nil {
+		minioClient.RemoveObject(objects.Bucket, tmpName)
+		return nil, fmt.Errorf("error storing file on S3: %v", err)
+	}
+	// the post is stored whole or not at all; an object left without a post
+	// is removed by the garbage collection
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	postId, err := insertDraft(tx, objectName, date, userId)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting post in database: %v", err)
+	}
+	err = insertTags(tx, postId, tags)
+	if err != nil {
+		return nil, fmt.Errorf("error creating tags for this post in database: %v", err)
+	}
+	if location != nil {
+		err = setLocation(tx, *postId, *location)
 		if err != nil {
-			return fmt.Errorf("error creating tags for this post in database: %v", err)
+			return nil, fmt.Errorf("error storing location of this post in database: %v", err)
 		}
 	}
-	return nil
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("error inserting post in database: %v", err)
+	}
+	// spare the first visitor the wait for the display image
+	go derivatives.Prerender(objectName)
+	return postId, nil
+}
+
+// detectFormat sniffs the content type of src without consuming it and
+// checks that it is an allowed image format.
+func detectFormat(src *bufio.Reader) (string, error) {
+	header, err := src.Peek(512)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("error reading file: %w", err)
+	}
+	contentType := sniffContentType(header)
+	if _, ok := allowedContentTypes[contentType]; !ok {
+		return "", fmt.Errorf("file type %s is not allowed", contentType)
+	}
+	return contentType, nil
+}
+
+// maxSizeReader fails with errFileTooLarge once more than max bytes are read.
+type maxSizeReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.read += int64(n)
+	if m.read > m.max {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// sniffContentType detects the content type from the first bytes of a file.
+// TIFF is checked by hand because net/http does not recognise it.
+func sniffContentType(header []byte) string {
+	if bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(header)
+}
+
+func createObjectName(year int, hashSum string, ext string) string {
+	return strconv.Itoa(year) + "/" + hashSum + "." + ext
 }
 
 func computeHashSum(file io.Reader) string {
 	h := sha1.New()
-	io.Copy(h, io.TeeReader(file, h))
+	io.Copy(h, file)
 	hashSum := fmt.Sprintf("%x", h.Sum(nil))
 	return hashSum
 }
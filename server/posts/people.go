@@ -0,0 +1,238 @@
+package posts
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"project/server/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Person is someone who is marked in photos with regions. Count and CoverURL
+// only cover the photos the visitor may see.
+type Person struct {
+	Id          int
+	UserId      int
+	Name        string
+	Description string
+	CreatedAt   time.Time
+	Count       int
+	CoverURL    string
+}
+
+// Region marks where a person is in a photo. Like the regions of the Metadata
+// Working Group, X and Y are its centre and Width and Height its size, all as
+// fractions of the image, so they export to XMP unchanged.
+type Region struct {
+	Id         int
+	PostId     int
+	PersonId   int
+	PersonName string
+	X          float64
+	Y          float64
+	Width      float64
+	Height     float64
+}
+
+// regionType is the MWG type of the regions: they all mark people.
+const regionType = "Face"
+
+// Style positions the region over the photo.
+func (r Region) Style() template.CSS {
+	return template.CSS(fmt.Sprintf("left: %.2f%%; top: %.2f%%; width: %.2f%%; height: %.2f%%;",
+		100*(r.X-r.Width/2), 100*(r.Y-r.Height/2), 100*r.Width, 100*r.Height))
+}
+
+// parseRegion reads a rectangle drawn on a photo, given by its top left
+// corner and size as fractions of the image.
+func parseRegion(left, top, width, height string) (Region, error) {
+	var values [4]float64
+	for i, value := range []string{left, top, width, height} {
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return Region{}, fmt.Errorf("region coordinate %q is not a number", value)
+		}
+		values[i] = n
+	}
+	// drawing up to the edge may overshoot by a rounding error
+	x0, x1 := clamp(values[0], 0, 1), clamp(values[0]+values[2], 0, 1)
+	y0, y1 := clamp(values[1], 0, 1), clamp(values[1]+values[3], 0, 1)
+	if x1 <= x0 || y1 <= y0 {
+		return Region{}, errors.New("region has no area inside the photo")
+	}
+	return Region{X: (x0 + x1) / 2, Y: (y0 + y1) / 2, Width: x1 - x0, Height: y1 - y0}, nil
+}
+
+// AddRegion marks the person with the given name in a post, adding the
+// person if they are new.
+func AddRegion(postId int, name string, region Region, userId int) (int, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("a region needs the name of a person")
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var personId, regionId int
+	err = tx.QueryRow(`
+		INSERT INTO people (USER_ID, NAME) VALUES ($1, $2)
+		ON CONFLICT (NAME) DO UPDATE SET NAME = EXCLUDED.NAME
+		RETURNING ID;`,
+		userId, name).Scan(&personId)
+	if err != nil {
+		return 0, err
+	}
+	err = tx.QueryRow(`
+		INSERT INTO regions (POST_ID, PERSON_ID, USER_ID, X, Y, WIDTH, HEIGHT)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ID;`,
+		postId, personId, userId, region.X, region.Y, region.Width, region.Height).Scan(&regionId)
+	if err != nil {
+		return 0, err
+	}
+	return regionId, tx.Commit()
+}
+
+func DeleteRegion(regionId, postId int) error {
+	_, err := config.DB.Exec("DELETE FROM regions WHERE id=$1 AND post_id=$2;", regionId, postId)
+	return err
+}
+
+// ListRegions returns the regions of a post from left to right, the order in
+// which captions name people.
+func ListRegions(postId int) ([]Region, error) {
+	regions := make([]Region, 0)
+	rows, err := config.DB.Query(`
+		SELECT r.id, r.post_id, r.person_id, pe.name, r.x, r.y, r.width, r.height
+		FROM regions r
+		JOIN people pe ON pe.id = r.person_id
+		WHERE r.post_id = $1
+		ORDER BY r.x, r.id;
+		`, postId)
+	if err != nil {
+		return regions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var region Region
+		err := rows.Scan(&region.Id, &region.PostId, &region.PersonId, &region.PersonName, &region.X, &region.Y, &region.Width, &region.Height)
+		if err != nil {
+			return regions, err
+		}
+		regions = append(regions, region)
+	}
+	return regions, rows.Err()
+}
+
+// personColumns lists the people columns in the order scanPerson expects
+// them, followed by the count and cover of the visible photos, which need
+// the people aliased pe joined to their regions and posts.
+const personColumns = `pe.id, pe.user_id, pe.name, pe.description, pe.created_at,
+	COUNT(DISTINCT p.id), COALESCE((ARRAY_AGG(p.minio_url ORDER BY p.id DESC) FILTER (WHERE p.id IS NOT NULL))[1], '')`
+
+func personJoin(showAll bool) string {
+	return `LEFT JOIN regions r ON r.person_id = pe.id
+		LEFT JOIN posts p ON p.id = r.post_id AND ` + VisibleCondition(showAll)
+}
+
+func scanPerson(row scanner) (Person, error) {
+	person := Person{}
+	err := row.Scan(&person.Id, &person.UserId, &person.Name, &person.Description, &person.CreatedAt, &person.Count, &person.CoverURL)
+	return person, err
+}
+
+// ListPeople returns the people by name. Visitors only see the people in a
+// photo they may see.
+func ListPeople(showAll bool) ([]Person, error) {
+	people := make([]Person, 0)
+	rows, err := config.DB.Query(`
+		SELECT `+personColumns+`
+		FROM people pe
+		`+personJoin(showAll)+`
+		GROUP BY pe.id
+		HAVING $1 OR COUNT(p.id) > 0
+		ORDER BY pe.name;
+		`, showAll)
+	if err != nil {
+		return people, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		person, err := scanPerson(rows)
+		if err != nil {
+			return people, err
+		}
+		people = append(people, person)
+	}
+	return people, rows.Err()
+}
+
+func GetPerson(personId int, showAll bool) (Person, error) {
+	row := config.DB.QueryRow(`
+		SELECT `+personColumns+`
+		FROM people pe
+		`+personJoin(showAll)+`
+		WHERE pe.id = $1
+		GROUP BY pe.id
+		HAVING $2 OR COUNT(p.id) > 0;
+		`, personId, showAll)
+	return scanPerson(row)
+}
+
+// ListPersonPosts returns the visible posts a person is marked in, oldest
+// first.
+func ListPersonPosts(personId int, showAll bool) ([]Post, error) {
+	postSlice := make([]Post, 0)
+	rows, err := config.DB.Query(`
+		SELECT `+postColumns+`, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE `+VisibleCondition(showAll)+`
+		AND p.id IN (SELECT post_id FROM regions WHERE person_id = $1)
+		GROUP BY p.id
+		ORDER BY `+chronologicalOrder+`;
+		`, personId)
+	if err != nil {
+		return postSlice, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return postSlice, err
+		}
+		postSlice = append(postSlice, post)
+	}
+	return postSlice, rows.Err()
+}
+
+var (
+	errNoPersonName = errors.New("a person needs a name")
+	errPersonExists = errors.New("another person already has this name")
+)
+
+func UpdatePerson(personId int, name, description string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errNoPersonName
+	}
+	_, err := config.DB.Exec("UPDATE people SET name=$1, description=$2 WHERE id=$3;",
+		name, strings.TrimSpace(description), personId)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return errPersonExists
+	}
+	return err
+}
+
+// DeletePerson removes a person together with their regions.
+func DeletePerson(personId int) error {
+	_, err := config.DB.Exec("DELETE FROM people WHERE id=$1;", personId)
+	return err
+}
@@ -0,0 +1,244 @@
+package posts
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"project/server/config"
+	"project/server/imagemeta"
+	"strconv"
+	"strings"
+)
+
+// maxMapPosts limits how many posts the map loads for one view.
+const maxMapPosts = 500
+
+// boundingBox is an area of the map in the GeoJSON order: west, south, east,
+// north.
+type boundingBox struct {
+	MinLongitude float64
+	MinLatitude  float64
+	MaxLongitude float64
+	MaxLatitude  float64
+}
+
+// parseBoundingBox reads "minLon,minLat,maxLon,maxLat". Maps that scroll past
+// the edge of the world send longitudes beyond 180, so they are clamped.
+func parseBoundingBox(value string) (boundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return boundingBox{}, fmt.Errorf("bounding box %q needs four coordinates", value)
+	}
+	var coordinates [4]float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return boundingBox{}, fmt.Errorf("bounding box coordinate %q is not a number", part)
+		}
+		coordinates[i] = n
+	}
+	box := boundingBox{
+		MinLongitude: clamp(coordinates[0], -180, 180),
+		MinLatitude:  clamp(coordinates[1], -90, 90),
+		MaxLongitude: clamp(coordinates[2], -180, 180),
+		MaxLatitude:  clamp(coordinates[3], -90, 90),
+	}
+	if box.MinLongitude > box.MaxLongitude || box.MinLatitude > box.MaxLatitude {
+		return boundingBox{}, fmt.Errorf("bounding box %q has its corners swapped", value)
+	}
+	return box, nil
+}
+
+func clamp(x, low, high float64) float64 {
+	if x < low {
+		return low
+	}
+	if x > high {
+		return high
+	}
+	return x
+}
+
+// Located reports whether the post is placed on the map.
+func (post Post) Located() bool {
+	return post.Latitude.Valid && post.Longitude.Valid
+}
+
+// Coordinates formats the location the way parseCoordinates reads it.
+func (post Post) Coordinates() string {
+	if !post.Located() {
+		return ""
+	}
+	return fmt.Sprintf("%.6f,%.6f", post.Latitude.Float64, post.Longitude.Float64)
+}
+
+// parseLocation reads the latitude and longitude fields of a form. Both empty
+// removes the location.
+func parseLocation(latitude, longitude string) (sql.NullFloat64, sql.NullFloat64, error) {
+	latitude, longitude = strings.TrimSpace(latitude), strings.TrimSpace(longitude)
+	if latitude == "" && longitude == "" {
+		return sql.NullFloat64{}, sql.NullFloat64{}, nil
+	}
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return sql.NullFloat64{}, sql.NullFloat64{}, fmt.Errorf("latitude %q is not between -90 and 90", latitude)
+	}
+	lon, err := strconv.ParseFloat(longitude, 64)
+	if err != nil || math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return sql.NullFloat64{}, sql.NullFloat64{}, fmt.Errorf("longitude %q is not between -180 and 180", longitude)
+	}
+	return sql.NullFloat64{Float64: lat, Valid: true}, sql.NullFloat64{Float64: lon, Valid: true}, nil
+}
+
+// parseCoordinates reads "latitude,longitude" as written by Coordinates.
+func parseCoordinates(value string) (sql.NullFloat64, sql.NullFloat64, error) {
+	latitude, longitude, _ := strings.Cut(value, ",")
+	return parseLocation(latitude, longitude)
+}
+
+// exifLocation reads the GPS position from the header of an upload without
+// consuming it. Photos without one, or with unreadable EXIF data, simply get
+// no location.
+func exifLocation(src *bufio.Reader) *imagemeta.Location {
+	header, err := src.Peek(imagemeta.HeaderSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil
+	}
+	location, err := imagemeta.ReadLocation(header)
+	if err != nil {
+		if !errors.Is(err, imagemeta.ErrNoLocation) {
+			log.Printf("ignoring EXIF location: %v", err)
+		}
+		return nil
+	}
+	return &location
+}
+
+func setLocation(db execer, postId int, location imagemeta.Location) error {
+	_, err := db.Exec(`
+		UPDATE posts SET LATITUDE=$1, LONGITUDE=$2 WHERE ID=$3;`,
+		location.Latitude, location.Longitude, postId)
+	return err
+}
+
+// listLocatedPosts returns the visible posts with a location inside box, or
+// anywhere when box is nil. A limit of zero returns all of them.
+func listLocatedPosts(box *boundingBox, showAll bool, limit int) ([]Post, error) {
+	postSlice := make([]Post, 0)
+	query := `
+		SELECT ` + postColumns + `, array_agg(t.name) AS tags
+		FROM posts p
+		LEFT JOIN tagmap tm ON p.id = tm.post_id
+		LEFT JOIN tags t ON tm.tag_id = t.id
+		WHERE ` + VisibleCondition(showAll) + `
+		AND p.latitude IS NOT NULL AND p.longitude IS NOT NULL`
+	args := []any{}
+	if box != nil {
+		query += `
+		AND p.latitude BETWEEN $1 AND $2
+		AND p.longitude BETWEEN $3 AND $4`
+		args = append(args, box.MinLatitude, box.MaxLatitude, box.MinLongitude, box.MaxLongitude)
+	}
+	query += `
+		GROUP BY p.id
+		ORDER BY p.id DESC`
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	rows, err := config.DB.Query(query+";", args...)
+	if err != nil {
+		return postSlice, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return postSlice, err
+		}
+		postSlice = append(postSlice, post)
+	}
+	return postSlice, rows.Err()
+}
+
+// The GeoJSON export (RFC 7946) has one point feature per located post.
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string            `json:"type"`
+	Geometry   point             `json:"geometry"`
+	Properties featureProperties `json:"properties"`
+}
+
+type point struct {
+	Type string `json:"type"`
+	// Coordinates holds the longitude before the latitude.
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type featureProperties struct {
+	Id          int      `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Place       string   `json:"place,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	Year        int      `json:"year"`
+	Tags        []string `json:"tags"`
+	URL         string   `json:"url"`
+	Image       string   `json:"image"`
+	// Provenance is left out for posts without any.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// newFeatureCollection exports the posts with the provenance recorded for
+// them, by post id.
+func newFeatureCollection(postSlice []Post, provenance map[int]Provenance) featureCollection {
+	collection := featureCollection{Type: "FeatureCollection", Features: make([]feature, 0, len(postSlice))}
+	for _, post := range postSlice {
+		tags := post.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		var postProvenance *Provenance
+		if p, ok := provenance[post.Id]; ok {
+			postProvenance = &p
+		}
+		collection.Features = append(collection.Features, feature{
+			Type: "Feature",
+			Geometry: point{
+				Type:        "Point",
+				Coordinates: [2]float64{post.Longitude.Float64, post.Latitude.Float64},
+			},
+			Properties: featureProperties{
+				Id:          post.Id,
+				Title:       post.Title,
+				Description: post.Description,
+				Place:       post.PlaceName,
+				Date:        post.Date.String(),
+				Year:        post.Year,
+				Tags:        tags,
+				URL:         fmt.Sprintf("/post/%d", post.Id),
+				Image:       "/blob/" + post.ImageURL,
+				Provenance:  postProvenance,
+			},
+		})
+	}
+	return collection
+}
+
+// mapTiles configures the tile layer of the maps.
+type mapTiles struct {
+	URL         string
+	Attribution string
+}
+
+func tiles() mapTiles {
+	return mapTiles{URL: config.MapTileURL, Attribution: config.MapAttribution}
+}
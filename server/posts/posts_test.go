@@ -2,8 +2,10 @@ package posts
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -11,11 +13,14 @@ import (
 	"os"
 	"path/filepath"
 	"project/server/config"
+	"project/server/downloads"
+	"project/server/objects"
 	"project/server/users"
 	"reflect"
 	"sort"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/minio/minio-go"
@@ -63,7 +68,7 @@ func TestListPost(t *testing.T) {
 	testCount := 3
 	for i := 0; i <= testCount; i++ {
 		// TODO this is using a *http.Request now!
-		posts, first, last, err := ListPosts(12, 0, year, "")
+		posts, first, last, err := ListPosts(12, 0, archiveFilter{Year: year})
 		if err != nil {
 			t.Error("Test failed because posts could not be listed.")
 		}
@@ -90,7 +95,7 @@ func TestListPost(t *testing.T) {
 	}
 	for i := 0; i <= testCount; i++ {
 		tagName := fmt.Sprintf("tag-%d", i)
-		posts, _, _, _ := ListPosts(12, 0, year, tagName)
+		posts, _, _, _ := ListPosts(12, 0, archiveFilter{Year: year, Tag: tagName})
 		if len(posts) != (testCount + 1 - i) {
 			t.Error("Test failed because tag filter is not working properly.")
 		}
@@ -140,7 +145,7 @@ func TestListLastPostPerTag(t *testing.T) {
 
 	}
 
-	posts, err := listTagReps()
+	posts, err := listTagReps(false)
 
 	if err != nil {
 		t.Error("Error querying for last post per tag")
@@ -151,7 +156,7 @@ func TestListLastPostPerTag(t *testing.T) {
 	}
 
 	for i, post := range posts {
-		if post.Id != (2 * (i + 1)) {
+		if post.PostId != (2 * (i + 1)) {
 			t.Error("This is not the last post")
 		}
 
@@ -174,10 +179,10 @@ func TestDeletePost(t *testing.T) {
 		{"post does not exist", 1, 1, true},
 		{"not ownded by user", 3, 2, true},
 	}
-	for _, c := range cases {
-		CreatePost("image", 2022, 1)
+	for i, c := range cases {
+		CreatePost(fmt.Sprintf("image-%d", i), 2022, 1)
 		DeletePost(c.PostId, c.UserId)
-		rows, _ := config.DB.Query("SELECT COUNT(*) FROM posts;")
+		rows, _ := config.DB.Query("SELECT COUNT(*) FROM posts WHERE deleted_at IS NULL;")
 		defer rows.Close()
 		for rows.Next() {
 			rows.Scan(&count)
@@ -318,7 +323,7 @@ func TestCreateNavigationProperties(t *testing.T) {
 	limit, offset, year := 10, 20, 2022
 	tag := "example"
 
-	props, prevProps, nextProps := createProperties(limit, offset, year, tag)
+	props, prevProps, nextProps := createProperties(limit, offset, archiveFilter{Year: year, Tag: tag})
 
 	// Check that the returned strings contain the correct values
 	if props != "limit=10&offset=20&year=2022&tag=example" {
@@ -333,7 +338,7 @@ func TestCreateNavigationProperties(t *testing.T) {
 
 	// Test the function without a tag
 	tag = ""
-	props, prevProps, nextProps = createProperties(limit, offset, year, tag)
+	props, prevProps, nextProps = createProperties(limit, offset, archiveFilter{Year: year, Tag: tag})
 	if props != "limit=10&offset=20&year=2022" {
 		t.Errorf("Incorrect prevProperties value: %s", prevProps)
 	}
@@ -346,7 +351,7 @@ func TestCreateNavigationProperties(t *testing.T) {
 
 	// Test the function without a year or tag
 	year, tag = 0, ""
-	props, prevProps, nextProps = createProperties(limit, offset, year, tag)
+	props, prevProps, nextProps = createProperties(limit, offset, archiveFilter{Year: year, Tag: tag})
 	if props != "limit=10&offset=20" {
 		t.Errorf("Incorrect prevProperties value: %s", prevProps)
 	}
@@ -356,6 +361,12 @@ func TestCreateNavigationProperties(t *testing.T) {
 	if nextProps != "limit=10&offset=30" {
 		t.Errorf("Incorrect nextProperties value without year or tag: %s", nextProps)
 	}
+
+	// Test the function with a search
+	props, _, _ = createProperties(limit, offset, archiveFilter{Year: year, Tag: tag, Search: "kermis 1960"})
+	if props != "limit=10&offset=20&q=kermis+1960" {
+		t.Errorf("Incorrect properties value with search: %s", props)
+	}
 }
 
 func TestCreateTags(t *testing.T) {
@@ -534,7 +545,7 @@ func TestListYears(t *testing.T) {
 	expectedYears12 := []int{1995, 1999, 2000, 2015, 2017, 2022, 2023}
 	expectedYearsAll := []int{1995, 1999, 2000, 2011, 2015, 2017, 2022, 2023}
 
-	years, err := listYears(tag1)
+	years, err := listYears(tag1, false)
 	if err != nil {
 		t.Errorf("Error listing years: %v", err)
 	}
@@ -542,7 +553,7 @@ func TestListYears(t *testing.T) {
 		t.Errorf("Error listing years with tag 1: %v", err)
 	}
 
-	years, err = listYears(tag2)
+	years, err = listYears(tag2, false)
 	if err != nil {
 		t.Errorf("Error listing years: %v", err)
 	}
@@ -550,7 +561,7 @@ func TestListYears(t *testing.T) {
 		t.Errorf("Error listing years with tag 2: %v", err)
 	}
 
-	years, err = listYears(tag12)
+	years, err = listYears(tag12, false)
 	if err != nil {
 		t.Errorf("Error listing years: %v", err)
 	}
@@ -558,7 +569,7 @@ func TestListYears(t *testing.T) {
 		t.Errorf("Error listing years with tags 1 and 2: %v", err)
 	}
 
-	years, err = listYears([]string{""})
+	years, err = listYears([]string{""}, false)
 	if err != nil {
 		t.Errorf("Error listing years: %v", err)
 	}
@@ -665,6 +676,7 @@ func TestUploadHandler(t *testing.T) {
 	// create a multipart form
 	var b bytes.Buffer
 	w := multipart.NewWriter(&b)
+	w.WriteField("year", "2022")
 	fw, _ := w.CreateFormFile("file", filepath.Join("test_data", "martian.jpg"))
 	src, _ := os.Open(filepath.Join("test_data", "martian.jpg"))
 	src.Seek(0, 0)
@@ -697,11 +709,6 @@ func TestUploadHandler(t *testing.T) {
 		}
 		req, _ := http.NewRequest("POST", "/upload", &b)
 		req.Header.Add("Content-Type", w.FormDataContentType())
-
-		// Add PostForm values
-		form := url.Values{}
-		form.Add("year", "2022")
-		req.PostForm = form
 		wr := httptest.NewRecorder()
 		if c.Login {
 			req.AddCookie(cookie)
@@ -803,6 +810,8 @@ func TestStoreFiles(t *testing.T) {
 	// create a multipart form
 	var b bytes.Buffer
 	w := multipart.NewWriter(&b)
+	w.WriteField("year", "2022")
+	w.WriteField("tags", "kermis,tilburg,kei")
 	fw, _ := w.CreateFormFile("file", filepath.Join("test_data", "martian.jpg"))
 	src, _ := os.Open(filepath.Join("test_data", "martian.jpg"))
 	srcHash := computeHashSum(src)
@@ -814,17 +823,15 @@ func TestStoreFiles(t *testing.T) {
 	// create a new request
 	req, _ := http.NewRequest("POST", "/upload", &b)
 	req.Header.Add("Content-Type", w.FormDataContentType())
-
-	// Add PostForm values
-	form := url.Values{}
-	form.Add("year", "2022")
-	form.Add("tags", "kermis,tilburg,kei")
-	req.PostForm = form
 	req.AddCookie(cookie)
 	// call the function
-	if err := storeFiles(req); err != nil {
+	results, err := storeFiles(httptest.NewRecorder(), req)
+	if err != nil {
 		t.Fatalf("error writing files to local storage: %v", err)
 	}
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("error storing file: %v", results)
+	}
 	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
 	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
 
@@ -833,7 +840,7 @@ func TestStoreFiles(t *testing.T) {
 	if err != nil {
 		t.Fatalf("error starting MinIO client: %v", err)
 	}
-	objectName := fmt.Sprintf("2022/%x.jpg", srcHash)
+	objectName := fmt.Sprintf("2022/%s.jpg", srcHash)
 	bucket := "download"
 	defer minioClient.RemoveObject(bucket, objectName)
 	// check if the file was written to minio storage
@@ -880,3 +887,1376 @@ func TestStoreFiles(t *testing.T) {
 		t.Errorf("Number of tags listed is incorrect %d!=%d.", 3, len(outputTags))
 	}
 }
+
+func TestSniffContentType(t *testing.T) {
+	jpeg, _ := os.ReadFile(filepath.Join("test_data", "martian.jpg"))
+	type Test struct {
+		Description string
+		Data        []byte
+		Expected    string
+	}
+	cases := []Test{
+		{"jpeg", jpeg, "image/jpeg"},
+		{"little endian tiff", []byte("II*\x00rest of the file"), "image/tiff"},
+		{"big endian tiff", []byte("MM\x00*rest of the file"), "image/tiff"},
+		{"text", []byte("not an image"), "text/plain; charset=utf-8"},
+		{"empty", []byte{}, "text/plain; charset=utf-8"},
+	}
+	for _, c := range cases {
+		contentType := sniffContentType(c.Data)
+		if contentType != c.Expected {
+			t.Errorf("Test '%s' failed because %s was expected instead of %s.", c.Description, c.Expected, contentType)
+		}
+	}
+}
+
+func TestMaxSizeReader(t *testing.T) {
+	type Test struct {
+		Description string
+		Size        int
+		Max         int64
+		ExpectedErr error
+	}
+	cases := []Test{
+		{"below limit", 10, 20, nil},
+		{"at limit", 20, 20, nil},
+		{"above limit", 21, 20, errFileTooLarge},
+	}
+	for _, c := range cases {
+		r := &maxSizeReader{r: bytes.NewReader(make([]byte, c.Size)), max: c.Max}
+		_, err := io.ReadAll(r)
+		if err != c.ExpectedErr {
+			t.Errorf("Test '%s' failed because error %v was expected instead of %v.", c.Description, c.ExpectedErr, err)
+		}
+	}
+}
+
+func TestParseTusMetadata(t *testing.T) {
+	type Test struct {
+		Description   string
+		Header        string
+		Expected      map[string]string
+		ExpectedError bool
+	}
+	cases := []Test{
+		{"empty", "", map[string]string{}, false},
+		{"single pair", "year MjAyMg==", map[string]string{"year": "2022"}, false},
+		{"multiple pairs", "year MjAyMg==, tags a2VybWlzLHRpbGJ1cmc=", map[string]string{"year": "2022", "tags": "kermis,tilburg"}, false},
+		{"key without value", "private", map[string]string{"private": ""}, false},
+		{"invalid base64", "year 2022!", nil, true},
+	}
+	for _, c := range cases {
+		metadata, err := parseTusMetadata(c.Header)
+		if (err != nil) != c.ExpectedError {
+			t.Errorf("Test '%s' failed because a different error was expected.", c.Description)
+		}
+		if err == nil && !reflect.DeepEqual(metadata, c.Expected) {
+			t.Errorf("Test '%s' failed because %v was expected instead of %v.", c.Description, c.Expected, metadata)
+		}
+	}
+}
+
+func TestTusHandlerOptions(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "/files/", nil)
+	w := httptest.NewRecorder()
+	TusHandler(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("OPTIONS returned %d instead of %d", w.Code, http.StatusNoContent)
+	}
+	if w.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("Tus-Version header is missing")
+	}
+}
+
+func TestExpireTusUploads(t *testing.T) {
+	email, password := users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	userId, _ := users.Login(email, password)
+	sessionID := uuid.NewV4().String()
+	users.SessionStore[sessionID] = *userId
+	cookie := &http.Cookie{Name: "session", Value: sessionID}
+
+	fresh, err := createTusUpload(*userId, 100, "")
+	if err != nil {
+		t.Fatalf("Upload could not be created: %v", err)
+	}
+	abandoned, err := createTusUpload(*userId, 100, "")
+	if err != nil {
+		t.Fatalf("Upload could not be created: %v", err)
+	}
+	config.DB.Exec("UPDATE tus_uploads SET created_at = $1 WHERE id = $2;", time.Now().Add(-2*config.TusUploadExpiry), abandoned)
+
+	req := httptest.NewRequest(http.MethodHead, "/files/"+abandoned, nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	TusHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("An expired upload should not be resumed, got %d", w.Code)
+	}
+
+	removed, err := expireTusUploads(config.TusUploadExpiry)
+	if err != nil || removed != 1 {
+		t.Errorf("Expected the abandoned upload to be removed, got %d: %v", removed, err)
+	}
+	var count int
+	config.DB.QueryRow("SELECT COUNT(*) FROM tus_uploads;").Scan(&count)
+	if count != 1 {
+		t.Errorf("Only the abandoned upload should be removed, %d uploads left", count)
+	}
+	if _, err := getTusUpload(fresh, *userId); err != nil {
+		t.Errorf("The fresh upload should be kept: %v", err)
+	}
+}
+
+func TestAppendTusChunkConflict(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	id, err := createTusUpload(1, 100, "")
+	if err != nil {
+		t.Fatalf("Upload could not be created: %v", err)
+	}
+	upload, _ := getTusUpload(id, 1)
+	defer deleteTusUpload(upload)
+	// another request stored the first chunk in the meantime
+	config.DB.Exec("UPDATE tus_uploads SET upload_offset=100, etags=ARRAY['winner'] WHERE id=$1;", id)
+	_, err = appendTusChunk(upload, bytes.NewReader(make([]byte, 100)), 100)
+	if err != errTusOffsetConflict {
+		t.Errorf("A chunk at a stale offset should conflict, got %v.", err)
+	}
+	upload, _ = getTusUpload(id, 1)
+	if len(upload.ETags) != 1 || upload.ETags[0] != "winner" {
+		t.Errorf("The stored part of the first request should be kept, got %v.", upload.ETags)
+	}
+}
+
+func TestInsertDraftRollback(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	tx, _ := config.DB.Begin()
+	postId, err := insertDraft(tx, "image", dateOfYear(2022), 1)
+	if err != nil {
+		t.Fatalf("Draft could not be inserted: %v", err)
+	}
+	if err := insertTags(tx, postId, []string{"kermis"}); err != nil {
+		t.Fatalf("Tags could not be inserted: %v", err)
+	}
+	tx.Rollback()
+	if drafts, _ := ListDrafts(1); len(drafts) != 0 {
+		t.Errorf("A rolled back draft should leave no post, got %d.", len(drafts))
+	}
+	if tags, _ := ListTags(); len(tags) != 0 {
+		t.Errorf("A rolled back draft should leave no tags, got %d.", len(tags))
+	}
+}
+
+func TestSaveDraft(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	postId, err := CreateDraft("image", dateOfYear(2022), 1)
+	if err != nil {
+		t.Fatalf("Draft could not be created: %v", err)
+	}
+	posts, _, _, _ := ListPosts(12, 0, archiveFilter{})
+	if len(posts) != 0 {
+		t.Error("Drafts should not be listed in the archive.")
+	}
+	drafts, err := ListDrafts(1)
+	if err != nil || len(drafts) != 1 {
+		t.Errorf("Draft was not listed for review: %v", err)
+	}
+	post := Post{Id: *postId, UserId: 1, Year: 1965, Title: "Kermis"}
+	if err := SaveDraft(post, false); err != nil {
+		t.Errorf("Draft could not be saved: %v", err)
+	}
+	posts, _, _, _ = ListPosts(12, 0, archiveFilter{})
+	if len(posts) != 0 {
+		t.Error("Saving a draft should not publish it.")
+	}
+	if err := SaveDraft(post, true); err != nil {
+		t.Errorf("Draft could not be published: %v", err)
+	}
+	posts, _, _, _ = ListPosts(12, 0, archiveFilter{})
+	if len(posts) != 1 || posts[0].Title != "Kermis" || posts[0].Year != 1965 {
+		t.Error("Published draft should be listed in the archive with its metadata.")
+	}
+}
+
+func TestPostVisibility(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	type Test struct {
+		Description string
+		Status      string
+		PublishAt   sql.NullTime
+		Public      bool
+	}
+	cases := []Test{
+		{"published", StatusPublished, sql.NullTime{}, true},
+		{"published in the past", StatusPublished, sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}, true},
+		{"scheduled", StatusPublished, sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}, false},
+		{"hidden", StatusHidden, sql.NullTime{}, false},
+		{"draft", StatusDraft, sql.NullTime{}, false},
+	}
+	for i, c := range cases {
+		postId, _ := CreatePost(fmt.Sprintf("image-%d", i), 2000+i, 1)
+		createTags(postId, []string{c.Description})
+		post, _ := GetPost(*postId)
+		post.Status = c.Status
+		post.PublishAt = c.PublishAt
+		if err := UpdatePost(post); err != nil {
+			t.Fatalf("Test '%s' failed because the post could not be updated: %v", c.Description, err)
+		}
+		anonymous, _, _, _ := ListPosts(12, 0, archiveFilter{Tag: c.Description})
+		if (len(anonymous) == 1) != c.Public {
+			t.Errorf("Test '%s' failed because a different visibility was expected.", c.Description)
+		}
+		years, _ := listYears([]string{c.Description}, false)
+		if (len(years) == 1) != c.Public {
+			t.Errorf("Test '%s' failed because a different year visibility was expected.", c.Description)
+		}
+		editor, _, _, _ := ListPosts(12, 0, archiveFilter{Tag: c.Description, ShowAll: true})
+		if len(editor) != 1 {
+			t.Errorf("Test '%s' failed because editors should see every post.", c.Description)
+		}
+	}
+	reps, _ := listTagReps(false)
+	if len(reps) != 2 {
+		t.Errorf("Only the public posts should represent a tag, got %d.", len(reps))
+	}
+}
+
+func TestParsePublishAt(t *testing.T) {
+	publishAt, err := parsePublishAt("")
+	if err != nil || publishAt.Valid {
+		t.Error("An empty value should publish right away.")
+	}
+	publishAt, err = parsePublishAt("2030-05-01T12:30")
+	if err != nil || !publishAt.Valid || publishAt.Time.Year() != 2030 || publishAt.Time.Local().Hour() != 12 {
+		t.Errorf("Publication date was parsed incorrectly: %v", publishAt)
+	}
+	if publishAt.Time.Location() != time.UTC {
+		t.Errorf("Publication date should be in UTC, got %v", publishAt.Time.Location())
+	}
+	_, err = parsePublishAt("tomorrow")
+	if err == nil {
+		t.Error("An invalid date should be rejected.")
+	}
+}
+
+func TestPublishAtTimeZone(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	postId, _ := CreatePost("image", 2022, 1)
+	post, _ := GetPost(*postId)
+	publishAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	post.PublishAt = sql.NullTime{Time: publishAt.In(time.FixedZone("UTC+14", 14*60*60)), Valid: true}
+	UpdatePost(post)
+
+	post, _ = GetPost(*postId)
+	if !post.PublishAt.Time.Equal(publishAt) || !post.Scheduled() {
+		t.Errorf("The publication date should keep its instant, got %v.", post.PublishAt.Time)
+	}
+	config.DB.Exec("UPDATE posts SET publish_at = $1 WHERE id = $2;", time.Now().Add(-time.Minute).In(time.FixedZone("UTC-12", -12*60*60)), *postId)
+	posts, _, _, _ := ListPosts(12, 0, archiveFilter{})
+	if len(posts) != 1 {
+		t.Error("A post whose publication date passed should be listed, whatever its time zone.")
+	}
+}
+
+func TestTrash(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	postId, _ := CreatePost("image", 2022, 1)
+	if err := DeletePost(*postId, 1); err != nil {
+		t.Fatalf("Post could not be deleted: %v", err)
+	}
+	posts, _, _, _ := ListPosts(12, 0, archiveFilter{ShowAll: true})
+	if len(posts) != 0 {
+		t.Error("Deleted posts should not be listed in the archive.")
+	}
+	trash, err := ListTrash()
+	if err != nil || len(trash) != 1 || trash[0].DeletedBy.Int64 != 1 {
+		t.Fatalf("Deleted post should be listed in the trash: %v", err)
+	}
+	if err := RestorePost(*postId); err != nil {
+		t.Fatalf("Post could not be restored: %v", err)
+	}
+	posts, _, _, _ = ListPosts(12, 0, archiveFilter{})
+	if len(posts) != 1 {
+		t.Error("Restored post should be listed in the archive again.")
+	}
+
+	DeletePost(*postId, 1)
+	purged, err := purgeExpired(time.Hour)
+	if err != nil || purged != 0 {
+		t.Errorf("Recently deleted post should not be purged: %v", err)
+	}
+	purged, err = purgeExpired(-time.Hour)
+	if err != nil || purged != 1 {
+		t.Errorf("Expired post should be purged: %v", err)
+	}
+	if _, err := GetPost(*postId); err != sql.ErrNoRows {
+		t.Error("Purged post should be removed from the database.")
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	postId, _ := CreatePost("image", 2022, 1)
+	createTags(postId, []string{"kermis"})
+
+	post, _ := GetPost(*postId)
+	before := post.snapshot()
+	post.Title = "Draaimolen"
+	post.Year = 1965
+	UpdatePost(post)
+	UpdateTags(postId, []string{"kermis", "tilburg"})
+	if err := recordUpdate(*postId, 1, before); err != nil {
+		t.Fatalf("Revision could not be recorded: %v", err)
+	}
+	// Saving without changes should not add a revision.
+	post, _ = GetPost(*postId)
+	if err := recordUpdate(*postId, 1, post.snapshot()); err != nil {
+		t.Fatalf("Revision could not be recorded: %v", err)
+	}
+
+	revisions, err := ListRevisions(*postId)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("Expected a single revision: %v", err)
+	}
+	if len(revisions[0].Changes()) != 3 {
+		t.Errorf("Expected title, year and tags to be changed, got %v", revisions[0].Changes())
+	}
+
+	if err := RevertPost(revisions[0], true, 1); err != nil {
+		t.Fatalf("Post could not be reverted: %v", err)
+	}
+	post, _ = GetPost(*postId)
+	if post.Title != "" || post.Year != 2022 || len(post.Tags) != 1 {
+		t.Errorf("Post was not reverted to its original state: %v", post)
+	}
+	revisions, _ = ListRevisions(*postId)
+	if len(revisions) != 2 {
+		t.Error("The revert should be recorded as a revision.")
+	}
+}
+
+func TestRevisionChanges(t *testing.T) {
+	revision := Revision{
+		Before: snapshot{Title: "a", Description: "b", Year: 1960, Tags: []string{"kermis"}},
+		After:  snapshot{Title: "a", Description: "c", Year: 1960, Tags: []string{"kermis", "tilburg"}},
+	}
+	changes := revision.Changes()
+	expected := []fieldChange{
+		{"description", "b", "c"},
+		{"tags", "kermis", "kermis, tilburg"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Changes() = %v, want %v", changes, expected)
+	}
+}
+
+func TestRevertOlderRevision(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	postId, _ := CreatePost("image", 1965, 1)
+	post, _ := GetPost(*postId)
+	post.Title = "Heuvel"
+	post.PlaceName = "Heuvel"
+	post.Latitude, post.Longitude, _ = parseLocation("51.5555", "5.0913")
+	post.Provenance = Provenance{FilmRoll: "12", Box: "Doos 3"}
+	post.Rights = Rights{Holder: "Fotopersbureau Meels", License: "CC-BY-4.0"}
+	UpdatePost(post)
+
+	// a revision recorded before posts had a location, provenance or rights
+	config.DB.Exec(`INSERT INTO revisions (POST_ID, USER_ID, BEFORE, AFTER) VALUES ($1, 1, $2, $3);`, *postId,
+		`{"Title":"Kermis","Description":"","Year":1965,"Tags":[]}`,
+		`{"Title":"Heuvel","Description":"","Year":1965,"Tags":[]}`)
+	revisions, err := ListRevisions(*postId)
+	if err != nil || len(revisions) != 1 {
+		t.Fatalf("Expected the older revision: %v", err)
+	}
+	if err := RevertPost(revisions[0], true, 1); err != nil {
+		t.Fatalf("Post could not be reverted: %v", err)
+	}
+	post, _ = GetPost(*postId)
+	if post.Title != "Kermis" {
+		t.Errorf("The title should be reverted, got %q.", post.Title)
+	}
+	if !post.Located() || post.PlaceName != "Heuvel" {
+		t.Errorf("Fields the revision predates should be kept, got %v %q.", post.Latitude, post.PlaceName)
+	}
+	if post.Provenance.FilmRoll != "12" || post.Provenance.Box != "Doos 3" {
+		t.Errorf("The provenance should be kept, got %v.", post.Provenance)
+	}
+	if post.Rights.Holder != "Fotopersbureau Meels" || post.Rights.License != "CC-BY-4.0" {
+		t.Errorf("The rights should be kept, got %v.", post.Rights)
+	}
+}
+
+func TestRevertHandlerUnknownRevision(t *testing.T) {
+	email, password := users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	userId, _ := users.Login(email, password)
+	sessionID := uuid.NewV4().String()
+	users.SessionStore[sessionID] = *userId
+
+	req := httptest.NewRequest(http.MethodPost, "/revert/12345", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+	w := httptest.NewRecorder()
+	RevertHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("An unknown revision should not be found, got %d.", w.Code)
+	}
+}
+
+func TestTagAdmin(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	first, _ := CreatePost("image-1", 2022, 1)
+	second, _ := CreatePost("image-2", 2022, 1)
+	UpdateTags(first, []string{"kermis", "tilburgse kermis"})
+	UpdateTags(second, []string{"kermis tilburg"})
+
+	count := func(name string) int {
+		tags, _ := ListTags()
+		for _, tag := range tags {
+			if tag.Name == name {
+				return tag.Count
+			}
+		}
+		return -1
+	}
+	id := func(name string) int {
+		var tagId int
+		config.DB.QueryRow("SELECT id FROM tags WHERE name=$1;", name).Scan(&tagId)
+		return tagId
+	}
+
+	if err := RenameTag(id("kermis tilburg"), "Tilburgse Kermis", 1); err != nil {
+		t.Fatalf("Tag could not be renamed: %v", err)
+	}
+	if count("kermis tilburg") != -1 || count("tilburgse kermis") != 2 {
+		t.Error("Renaming to an existing tag should merge both tags.")
+	}
+	if err := MergeTags(id("tilburgse kermis"), id("kermis"), 1); err != nil {
+		t.Fatalf("Tags could not be merged: %v", err)
+	}
+	if count("kermis") != 2 {
+		t.Errorf("Merged tag should not create duplicate links, got %d posts.", count("kermis"))
+	}
+	if err := DeleteTag(id("kermis"), 1); err != nil {
+		t.Fatalf("Tag could not be deleted: %v", err)
+	}
+	if count("kermis") != -1 {
+		t.Error("Deleted tag should not be listed.")
+	}
+	audit, err := ListTagAudit(10)
+	if err != nil || len(audit) != 3 || audit[0].Action != "delete" {
+		t.Errorf("Every change should be in the audit trail: %v", err)
+	}
+}
+
+func TestTagHierarchy(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	first, _ := CreatePost("image-1", 2021, 1)
+	second, _ := CreatePost("image-2", 2022, 1)
+	createTags(first, []string{"tilburg"})
+	createTags(second, []string{"heikant"})
+	var tilburg, heikant int
+	config.DB.QueryRow("SELECT id FROM tags WHERE name='tilburg';").Scan(&tilburg)
+	config.DB.QueryRow("SELECT id FROM tags WHERE name='heikant';").Scan(&heikant)
+
+	if err := ClassifyTag(heikant, TagPlace, tilburg, 1); err != nil {
+		t.Fatalf("Tag could not be classified: %v", err)
+	}
+	if err := ClassifyTag(tilburg, TagPlace, heikant, 1); err == nil {
+		t.Error("A tag should not be placed below its own descendant.")
+	}
+	if err := ClassifyTag(tilburg, "city", 0, 1); err == nil {
+		t.Error("An unknown tag type should be rejected.")
+	}
+	posts, _, _, _ := ListPosts(12, 0, archiveFilter{Tag: "tilburg"})
+	if len(posts) != 2 {
+		t.Errorf("Filtering on a parent should include its descendants, got %d posts.", len(posts))
+	}
+	posts, _, _, _ = ListPosts(12, 0, archiveFilter{Tag: "heikant"})
+	if len(posts) != 1 {
+		t.Errorf("Filtering on a child should not include its parent, got %d posts.", len(posts))
+	}
+	years, _ := listYears([]string{"tilburg"}, false)
+	if len(years) != 2 {
+		t.Errorf("Years of descendants should be listed, got %v.", years)
+	}
+
+	reps, _ := listTagReps(false)
+	groups := groupTagReps(reps)
+	if len(groups) != 1 || groups[0].Type != TagPlace || len(groups[0].Reps) != 2 {
+		t.Error("Tag representatives should be grouped by type.")
+	}
+	// merging a tag into its grandchild lifts the grandchild first
+	createTags(first, []string{"brabant", "oisterwijk"})
+	var brabant, oisterwijk int
+	config.DB.QueryRow("SELECT id FROM tags WHERE name='brabant';").Scan(&brabant)
+	config.DB.QueryRow("SELECT id FROM tags WHERE name='oisterwijk';").Scan(&oisterwijk)
+	ClassifyTag(tilburg, TagPlace, brabant, 1)
+	ClassifyTag(oisterwijk, TagPlace, brabant, 1)
+	if err := MergeTags(brabant, heikant, 1); err != nil {
+		t.Fatalf("Tag could not be merged into its grandchild: %v", err)
+	}
+	parentOf := func(tagId int) sql.NullInt64 {
+		var parentId sql.NullInt64
+		config.DB.QueryRow("SELECT parent_id FROM tags WHERE id=$1;", tagId).Scan(&parentId)
+		return parentId
+	}
+	if parentOf(heikant).Valid || parentOf(tilburg).Int64 != int64(heikant) || parentOf(oisterwijk).Int64 != int64(heikant) {
+		t.Errorf("The grandchild should take the place of the merged tag, got parents %v %v %v.", parentOf(heikant), parentOf(tilburg), parentOf(oisterwijk))
+	}
+	posts, _, _, _ = ListPosts(12, 0, archiveFilter{Tag: "heikant"})
+	if len(posts) != 2 {
+		t.Errorf("The merged tree should be filtered without a cycle, got %d posts.", len(posts))
+	}
+}
+
+func TestTagAliases(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	first, _ := CreatePost("image-1", 1960, 1)
+	createTags(first, []string{"vastenavond"})
+	var tagId int
+	config.DB.QueryRow("SELECT id FROM tags WHERE name='vastenavond';").Scan(&tagId)
+
+	if err := AddTagAlias(tagId, " Carnaval ", 1); err != nil {
+		t.Fatalf("Alias could not be added: %v", err)
+	}
+	if err := AddTagAlias(tagId, "vastenavond", 1); err == nil {
+		t.Error("An existing tag should not become an alias.")
+	}
+	second, _ := CreatePost("image-2", 1961, 1)
+	createTags(second, []string{"carnaval"})
+	tags, _ := ListTags()
+	if len(tags) != 1 || tags[0].Count != 2 || !reflect.DeepEqual(tags[0].Aliases, []string{"carnaval"}) {
+		t.Errorf("Tagging with an alias should use the canonical tag, got %v.", tags)
+	}
+	posts, _, _, _ := ListPosts(12, 0, archiveFilter{Tag: "carnaval"})
+	if len(posts) != 2 {
+		t.Errorf("Filtering on an alias should list the canonical tag, got %d posts.", len(posts))
+	}
+
+	UpdatePost(Post{Id: *first, UserId: 1, Title: "Optocht", Description: "De optocht door de Heuvelstraat", Year: 1960, Status: StatusPublished})
+	cases := []struct {
+		Search   string
+		Expected int
+	}{
+		{"optocht", 1},
+		{"HEUVELSTRAAT", 1},
+		{"vasten", 2},
+		{"carnaval", 2},
+		{"optocht carnaval", 1},
+		{"100%", 0},
+	}
+	for _, c := range cases {
+		posts, _, _, _ := ListPosts(12, 0, archiveFilter{Search: c.Search})
+		if len(posts) != c.Expected {
+			t.Errorf("Search '%s' found %d posts, want %d.", c.Search, len(posts), c.Expected)
+		}
+	}
+
+	if err := RemoveTagAlias(tagId, "carnaval", 1); err != nil {
+		t.Fatalf("Alias could not be removed: %v", err)
+	}
+	if name, _ := resolveAlias("carnaval"); name != "carnaval" {
+		t.Error("A removed alias should not resolve anymore.")
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		A, B     string
+		Expected int
+	}{
+		{"kermis", "kermis", 0},
+		{"kermis", "kremis", 2},
+		{"kermis", "kermiss", 1},
+		{"", "tilburg", 7},
+		{"vastenavond", "vastenaovnd", 2},
+		{"café", "cafe", 1},
+	}
+	for _, c := range cases {
+		if distance := levenshtein(c.A, c.B); distance != c.Expected {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", c.A, c.B, distance, c.Expected)
+		}
+	}
+}
+
+func TestSuggestTags(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	first, _ := CreatePost("image-1", 1960, 1)
+	second, _ := CreatePost("image-2", 1961, 1)
+	createTags(first, []string{"kermis", "tilburgse kermis", "vastenavond"})
+	createTags(second, []string{"tilburgse kermis"})
+	var tagId int
+	config.DB.QueryRow("SELECT id FROM tags WHERE name='vastenavond';").Scan(&tagId)
+	AddTagAlias(tagId, "carnaval", 1)
+
+	cases := []struct {
+		Query    string
+		Expected []string
+	}{
+		{"", []string{"tilburgse kermis", "kermis", "vastenavond"}},
+		{"ker", []string{"kermis", "tilburgse kermis"}},
+		{"carn", []string{"vastenavond"}},
+		{"vastenavnod", []string{"vastenavond"}},
+		{"xyz", []string{}},
+	}
+	for _, c := range cases {
+		suggestions, err := SuggestTags(c.Query, 10)
+		if err != nil {
+			t.Fatalf("Tags could not be suggested: %v", err)
+		}
+		names := make([]string, 0)
+		for _, suggestion := range suggestions {
+			names = append(names, suggestion.Name)
+		}
+		if !reflect.DeepEqual(names, c.Expected) {
+			t.Errorf("Query '%s' suggested %v, want %v", c.Query, names, c.Expected)
+		}
+	}
+}
+
+func TestCurateTag(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	for i := 0; i < 3; i++ {
+		postId, _ := CreatePost(fmt.Sprintf("image-%d", i), 1960, 1)
+		createTags(postId, []string{"kermis"})
+	}
+	postId, _ := CreatePost("image-3", 1960, 1)
+	createTags(postId, []string{"tilburg"})
+	var tagId int
+	config.DB.QueryRow("SELECT id FROM tags WHERE name='kermis';").Scan(&tagId)
+
+	reps, _ := listTagReps(false)
+	if len(reps) != 2 || reps[0].Name != "kermis" || reps[0].PostId != 3 {
+		t.Fatalf("Without a cover the latest post should represent the tag, got %v.", reps)
+	}
+	if err := CurateTag(tagId, " De Tilburgse kermis ", 1, 1, 1); err != nil {
+		t.Fatalf("Tag could not be curated: %v", err)
+	}
+	reps, _ = listTagReps(false)
+	if reps[1].Name != "kermis" || reps[1].PostId != 1 || reps[1].Description != "De Tilburgse kermis" {
+		t.Errorf("The cover and display order should be used, got %v.", reps)
+	}
+	if err := SetTagCover("kermis", 2, 1); err != nil {
+		t.Fatalf("Cover could not be set: %v", err)
+	}
+	if err := SetTagCover("carnaval", 2, 1); err == nil {
+		t.Error("Setting the cover of an unknown tag should fail.")
+	}
+	DeletePost(2, 1)
+	reps, _ = listTagReps(false)
+	if reps[1].PostId != 3 {
+		t.Errorf("A deleted cover should fall back to the latest post, got %d.", reps[1].PostId)
+	}
+}
+
+func TestParseFuzzyDate(t *testing.T) {
+	type Test struct {
+		Value         string
+		Precision     string
+		Start         string
+		End           string
+		Year          int
+		String        string
+		ExpectedError bool
+	}
+	cases := []Test{
+		{"1965-07-14", PrecisionDay, "1965-07-14", "1965-07-14", 1965, "1965-07-14", false},
+		{"1965-02", PrecisionMonth, "1965-02-01", "1965-02-28", 1965, "1965-02", false},
+		{" 1965 ", PrecisionYear, "1965-01-01", "1965-12-31", 1965, "1965", false},
+		{"1960s", PrecisionDecade, "1960-01-01", "1969-12-31", 1960, "1960s", false},
+		{"Ca. 1965", PrecisionCirca, "1963-01-01", "1967-12-31", 1965, "ca. 1965", false},
+		{"circa 1965", PrecisionCirca, "1963-01-01", "1967-12-31", 1965, "ca. 1965", false},
+		{"1962-1968", PrecisionCirca, "1962-01-01", "1968-12-31", 1965, "1962-1968", false},
+		{"1968-1962", "", "", "", 0, "", true},
+		{"1965-13", "", "", "", 0, "", true},
+		{"1965-02-30", "", "", "", 0, "", true},
+		{"165", "", "", "", 0, "", true},
+		{"1765", "", "", "", 0, "", true},
+		{"2999", "", "", "", 0, "", true},
+		{"summer", "", "", "", 0, "", true},
+	}
+	for _, c := range cases {
+		date, err := parseFuzzyDate(c.Value)
+		if (err != nil) != c.ExpectedError {
+			t.Errorf("Test '%s' failed because a different error was expected: %v", c.Value, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if date.Precision != c.Precision || date.Start.Format("2006-01-02") != c.Start || date.End.Format("2006-01-02") != c.End {
+			t.Errorf("Test '%s' failed: got %s from %v to %v", c.Value, date.Precision, date.Start, date.End)
+		}
+		if date.Year() != c.Year || date.String() != c.String {
+			t.Errorf("Test '%s' failed: got year %d and string %q", c.Value, date.Year(), date.String())
+		}
+	}
+}
+
+func TestParseDateField(t *testing.T) {
+	cases := []struct {
+		Value, Year   string
+		Start         string
+		ExpectedError bool
+	}{
+		{"1960s", "1972", "1960-01-01", false},
+		{"", "1965", "1965-01-01", false},
+		{"", " 1965 ", "1965-01-01", false},
+		{"", "165", "", true},
+		{"", "0", "", true},
+		{"", "2999", "", true},
+		{"", "kermis", "", true},
+	}
+	for _, c := range cases {
+		date, err := parseDateField(c.Value, c.Year)
+		if (err != nil) != c.ExpectedError {
+			t.Errorf("Test '%s'/'%s' failed because a different error was expected: %v", c.Value, c.Year, err)
+			continue
+		}
+		if err == nil && date.Start.Format("2006-01-02") != c.Start {
+			t.Errorf("Test '%s'/'%s' failed: got %v", c.Value, c.Year, date.Start)
+		}
+	}
+}
+
+func TestGroupDecades(t *testing.T) {
+	decades := groupDecades([]int{1958, 1961, 1965, 1969, 1970})
+	expected := []decade{{1950, []int{1958}}, {1960, []int{1961, 1965, 1969}}, {1970, []int{1970}}}
+	if !reflect.DeepEqual(decades, expected) {
+		t.Errorf("groupDecades() = %v, want %v", decades, expected)
+	}
+}
+
+func TestFuzzyDates(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	for i, value := range []string{"1960s", "1965-07-14", "ca. 1965", "1965", "1971"} {
+		date, _ := parseFuzzyDate(value)
+		postId, _ := CreateDraft(fmt.Sprintf("image-%d", i), date, 1)
+		post, _ := GetPost(*postId)
+		if post.Date.String() != date.String() || post.Year != date.Year() {
+			t.Errorf("Date %s was not stored: got %v and year %d", value, post.Date, post.Year)
+		}
+		SaveDraft(post, true)
+	}
+
+	posts, _, _, _ := ListPosts(12, 0, archiveFilter{Decade: 1960})
+	order := make([]string, 0)
+	for _, post := range posts {
+		order = append(order, post.Date.String())
+	}
+	expected := []string{"1960s", "ca. 1965", "1965", "1965-07-14"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Decade should be sorted by the middle of each date range, got %v, want %v", order, expected)
+	}
+
+	post, _ := GetPost(2)
+	before := post.snapshot()
+	post.Date, _ = parseFuzzyDate("1965-07")
+	post.Year = post.Date.Year()
+	UpdatePost(post)
+	recordUpdate(2, 1, before)
+	revisions, _ := ListRevisions(2)
+	if len(revisions) != 1 || !reflect.DeepEqual(revisions[0].Changes(), []fieldChange{{"date", "1965-07-14", "1965-07"}}) {
+		t.Fatalf("Changing the date should be recorded in the history: %v", revisions)
+	}
+	RevertPost(revisions[0], true, 1)
+	post, _ = GetPost(2)
+	if post.Date.String() != "1965-07-14" {
+		t.Errorf("Reverting should restore the date, got %s", post.Date)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tagmap RESTART IDENTITY CASCADE;")
+	years := []int{1958, 1961, 1961, 1961, 1961, 1961, 1969, 0}
+	for i, year := range years {
+		postId, _ := CreatePost(fmt.Sprintf("image-%d", i), year, 1)
+		if i%2 == 0 {
+			createTags(postId, []string{"kermis"})
+		}
+	}
+	CreateDraft("draft", dateOfYear(1975), 1)
+
+	decades, err := listTimeline("", false)
+	if err != nil {
+		t.Fatalf("Timeline could not be listed: %v", err)
+	}
+	if len(decades) != 2 || decades[0].Start != 1950 || decades[1].Start != 1960 || decades[1].Count != 6 {
+		t.Fatalf("Public dated posts should be grouped by decade, got %v", decades)
+	}
+	sixties := decades[1].Years
+	if len(sixties) != 2 || sixties[0].Year != 1961 || sixties[0].Count != 5 || len(sixties[0].Thumbnails) != thumbnailsPerYear {
+		t.Errorf("Years should be counted with at most %d thumbnails, got %v", thumbnailsPerYear, sixties)
+	}
+	decades, _ = listTimeline("", true)
+	if len(decades) != 3 {
+		t.Errorf("Editors should see drafts on the timeline, got %v", decades)
+	}
+	decades, _ = listTimeline("kermis", false)
+	if len(decades) != 2 || decades[1].Count != 3 {
+		t.Errorf("Timeline of a tag should only count its posts, got %v", decades)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	for i, value := range []string{"1971-07-14", "1965-07-14", "1965-07", "1968-07-15"} {
+		date, _ := parseFuzzyDate(value)
+		postId, _ := CreateDraft(fmt.Sprintf("image-%d", i), date, 1)
+		post, _ := GetPost(*postId)
+		SaveDraft(post, i != 0)
+	}
+	day := time.Date(2000, time.July, 14, 0, 0, 0, 0, time.UTC)
+	posts, err := ListOnThisDay(day, false)
+	if err != nil || len(posts) != 1 || posts[0].Id != 2 {
+		t.Errorf("Only public posts dated to the day should be listed: %v", err)
+	}
+	posts, _ = ListOnThisDay(day, true)
+	if len(posts) != 2 || posts[0].Id != 2 || posts[1].Id != 1 {
+		t.Errorf("Posts should be listed from old to new for editors, got %d posts.", len(posts))
+	}
+
+	for i := 0; i < 10; i++ {
+		postId, err := randomPostId(false)
+		if err != nil || postId == 1 {
+			t.Fatalf("A random post should be public: %d %v", postId, err)
+		}
+	}
+
+	first, err := FeaturedPost(day)
+	if err != nil || !first.Public() {
+		t.Fatalf("The photo of the day should be public: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		CreatePost(fmt.Sprintf("later-%d", i), 2000, 1)
+	}
+	again, err := FeaturedPost(day)
+	if err != nil || again.Id != first.Id {
+		t.Errorf("Publishing posts should not change the photo of the day: %v", err)
+	}
+	DeletePost(first.Id, 1)
+	second, err := FeaturedPost(day)
+	if err != nil || second.Id == first.Id {
+		t.Errorf("A deleted photo of the day should be replaced: %v", err)
+	}
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	tests := []struct {
+		value string
+		box   boundingBox
+		ok    bool
+	}{
+		{"5.0,51.5,5.2,51.6", boundingBox{5.0, 51.5, 5.2, 51.6}, true},
+		{" 5.0, 51.5 ,5.2,51.6", boundingBox{5.0, 51.5, 5.2, 51.6}, true},
+		{"-200,-95,200,95", boundingBox{-180, -90, 180, 90}, true},
+		{"5.2,51.5,5.0,51.6", boundingBox{}, false},
+		{"5.0,51.5,5.2", boundingBox{}, false},
+		{"5.0,noord,5.2,51.6", boundingBox{}, false},
+		{"NaN,51.5,5.2,51.6", boundingBox{}, false},
+		{"-Inf,51.5,+Inf,51.6", boundingBox{}, false},
+	}
+	for _, test := range tests {
+		box, err := parseBoundingBox(test.value)
+		if (err == nil) != test.ok || box != test.box {
+			t.Errorf("Parsing %q gave %v %v, expected %v.", test.value, box, err, test.box)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		latitude, longitude string
+		valid, ok           bool
+	}{
+		{"51.5555", "5.0913", true, true},
+		{"", "", false, true},
+		{"51.5555", "", false, false},
+		{"91", "5.0913", false, false},
+		{"51.5555", "-181", false, false},
+		{"NaN", "5.0913", false, false},
+		{"51.5555", "nan", false, false},
+		{"Inf", "5.0913", false, false},
+	}
+	for _, test := range tests {
+		lat, lon, err := parseLocation(test.latitude, test.longitude)
+		if (err == nil) != test.ok || lat.Valid != test.valid || lon.Valid != test.valid {
+			t.Errorf("Parsing %q,%q gave %v %v %v.", test.latitude, test.longitude, lat, lon, err)
+		}
+	}
+	post := Post{Latitude: sql.NullFloat64{Float64: 51.5555, Valid: true}, Longitude: sql.NullFloat64{Float64: 5.0913, Valid: true}}
+	lat, lon, err := parseCoordinates(post.Coordinates())
+	if err != nil || lat != post.Latitude || lon != post.Longitude {
+		t.Errorf("Coordinates should round-trip, got %v %v %v.", lat, lon, err)
+	}
+}
+
+func TestLocatedPosts(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	places := []struct {
+		name                string
+		latitude, longitude string
+	}{
+		{"Heuvel", "51.5555", "5.0913"},
+		{"Spoorzone", "51.5605", "5.0838"},
+		{"Eindhoven", "51.4416", "5.4697"},
+		{"", "", ""},
+	}
+	for i, place := range places {
+		postId, _ := CreateDraft(fmt.Sprintf("image-%d", i), dateOfYear(1965), 1)
+		post, _ := GetPost(*postId)
+		before := post.snapshot()
+		post.Status = StatusPublished
+		post.PlaceName = place.name
+		post.Latitude, post.Longitude, _ = parseLocation(place.latitude, place.longitude)
+		if err := UpdatePost(post); err != nil {
+			t.Fatal(err)
+		}
+		if after := post.snapshot(); i < 3 && (optional(after.Location) == optional(before.Location) || optional(after.PlaceName) != place.name) {
+			t.Errorf("The snapshot should track the location, got %v.", after)
+		}
+	}
+	post, _ := GetPost(1)
+	if !post.Located() || post.Latitude.Float64 != 51.5555 || post.PlaceName != "Heuvel" {
+		t.Errorf("The location should be stored, got %v %v %q.", post.Latitude, post.Longitude, post.PlaceName)
+	}
+
+	tilburg := boundingBox{5.0, 51.5, 5.2, 51.6}
+	located, err := listLocatedPosts(&tilburg, false, 0)
+	if err != nil || len(located) != 2 || located[0].Id != 2 || located[1].Id != 1 {
+		t.Errorf("Only the posts in the bounding box should be listed: %v", err)
+	}
+	located, _ = listLocatedPosts(&tilburg, false, 1)
+	if len(located) != 1 {
+		t.Errorf("The limit should apply, got %d posts.", len(located))
+	}
+	located, _ = listLocatedPosts(nil, false, 0)
+	if len(located) != 3 {
+		t.Errorf("Every located post should be exported, got %d posts.", len(located))
+	}
+	DeletePost(3, 1)
+	located, _ = listLocatedPosts(nil, false, 0)
+	if len(located) != 2 {
+		t.Errorf("Deleted posts should not be exported, got %d posts.", len(located))
+	}
+
+	collection := newFeatureCollection(located, nil)
+	first := collection.Features[0]
+	if first.Geometry.Coordinates != [2]float64{5.0838, 51.5605} || first.Properties.Place != "Spoorzone" || first.Properties.URL != "/post/2" {
+		t.Errorf("Features should hold the longitude first, got %v.", first)
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		left, top, width, height string
+		region                   Region
+		ok                       bool
+	}{
+		{"0.2", "0.4", "0.2", "0.2", Region{X: 0.3, Y: 0.5, Width: 0.2, Height: 0.2}, true},
+		{"0.9", "-0.1", "0.2", "0.3", Region{X: 0.95, Y: 0.1, Width: 0.1, Height: 0.2}, true},
+		{"0.2", "0.4", "0", "0.2", Region{}, false},
+		{"1.2", "0.4", "0.2", "0.2", Region{}, false},
+		{"links", "0.4", "0.2", "0.2", Region{}, false},
+		{"NaN", "0.4", "0.2", "0.2", Region{}, false},
+		{"0.2", "0.4", "+Inf", "0.2", Region{}, false},
+	}
+	for _, test := range tests {
+		region, err := parseRegion(test.left, test.top, test.width, test.height)
+		if (err == nil) != test.ok {
+			t.Errorf("Parsing %v gave %v.", test, err)
+			continue
+		}
+		for _, pair := range [][2]float64{{region.X, test.region.X}, {region.Y, test.region.Y}, {region.Width, test.region.Width}, {region.Height, test.region.Height}} {
+			if math.Abs(pair[0]-pair[1]) > 1e-9 {
+				t.Errorf("Parsing %v gave %v, expected %v.", test, region, test.region)
+				break
+			}
+		}
+	}
+}
+
+func TestPeople(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	for i := 0; i < 3; i++ {
+		postId, _ := CreateDraft(fmt.Sprintf("image-%d", i), dateOfYear(1960+i), 1)
+		post, _ := GetPost(*postId)
+		SaveDraft(post, i != 0)
+	}
+	right := Region{X: 0.7, Y: 0.5, Width: 0.2, Height: 0.3}
+	left := Region{X: 0.2, Y: 0.5, Width: 0.2, Height: 0.3}
+	if _, err := AddRegion(3, "Jan", right, 1); err != nil {
+		t.Fatal(err)
+	}
+	AddRegion(3, " Marie ", left, 1)
+	AddRegion(2, "Jan", left, 1)
+	AddRegion(1, "Kees", left, 1)
+	if _, err := AddRegion(1, " ", left, 1); err == nil {
+		t.Error("A region without a name should be rejected.")
+	}
+
+	regions, err := ListRegions(3)
+	if err != nil || len(regions) != 2 || regions[0].PersonName != "Marie" || regions[1].PersonName != "Jan" {
+		t.Errorf("Regions should be listed from left to right: %v %v", regions, err)
+	}
+	people, _ := ListPeople(false)
+	if len(people) != 2 || people[0].Name != "Jan" || people[0].Count != 2 || people[0].CoverURL != "image-2" {
+		t.Errorf("Visitors should only see people in public photos, got %v.", people)
+	}
+	people, _ = ListPeople(true)
+	if len(people) != 3 {
+		t.Errorf("Editors should see everyone, got %d people.", len(people))
+	}
+	kees := people[1]
+	if _, err := GetPerson(kees.Id, false); err != sql.ErrNoRows {
+		t.Errorf("Visitors should not find people only in drafts: %v", err)
+	}
+	postSlice, _ := ListPersonPosts(regions[1].PersonId, false)
+	if len(postSlice) != 2 || postSlice[0].Id != 2 {
+		t.Errorf("A person's photos should be listed from old to new, got %d posts.", len(postSlice))
+	}
+
+	if err := UpdatePerson(regions[1].PersonId, kees.Name, ""); err != errPersonExists {
+		t.Errorf("Renaming to the name of another person should be refused, got %v.", err)
+	}
+	UpdatePerson(regions[1].PersonId, "Jan de Vries", "Fotograaf")
+	DeleteRegion(regions[0].Id, 3)
+	regions, _ = ListRegions(3)
+	if len(regions) != 1 || regions[0].PersonName != "Jan de Vries" {
+		t.Errorf("The region should be removed and the person renamed, got %v.", regions)
+	}
+	DeletePerson(kees.Id)
+	if regions, _ := ListRegions(1); len(regions) != 0 {
+		t.Error("Deleting a person should remove their regions.")
+	}
+
+	post, _ := GetPost(3)
+	regions, _ = ListRegions(3)
+	x := postXMP(post, regions, 3000, 2000)
+	if len(x.Regions) != 1 || x.Regions[0].Name != "Jan de Vries" || x.Regions[0].Type != "Face" || x.Regions[0].X != 0.7 {
+		t.Errorf("The XMP should hold the regions unchanged, got %v.", x.Regions)
+	}
+}
+
+func TestParseProvenance(t *testing.T) {
+	form := url.Values{
+		"box":             {" 12 "},
+		"film_roll":       {"R34"},
+		"negative_number": {"7"},
+		"scanner":         {"Nikon Coolscan"},
+		"scanned_on":      {"2023-04-01"},
+		"scan_resolution": {"4000"},
+	}
+	provenance, err := parseProvenance(form)
+	expected := Provenance{FilmRoll: "R34", NegativeNumber: "7", Box: "12", Scanner: "Nikon Coolscan", ScannedOn: "2023-04-01", Resolution: 4000}
+	if err != nil || provenance != expected {
+		t.Errorf("Expected %v, got %v %v", expected, provenance, err)
+	}
+	if provenance.Reference() != "box 12, roll R34, negative 7" {
+		t.Errorf("Unexpected reference %q", provenance.Reference())
+	}
+	for field, value := range map[string]string{"scanned_on": "01-04-2023", "scan_resolution": "-300"} {
+		invalid := url.Values{field: {value}}
+		if _, err := parseProvenance(invalid); err == nil {
+			t.Errorf("%s %q should be rejected.", field, value)
+		}
+	}
+	if provenance, _ := parseProvenance(url.Values{}); provenance.Known() {
+		t.Error("An empty form should record no provenance.")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	postId, _ := CreateDraft("image-0", dateOfYear(1965), 1)
+	CreateDraft("image-1", dateOfYear(1965), 1)
+	post, _ := GetPost(*postId)
+	before := post.snapshot()
+	post.Status = StatusPublished
+	post.Provenance = Provenance{FilmRoll: "R34", NegativeNumber: "7", Box: "12", ScannedOn: "2023-04-01", Resolution: 4000}
+	if err := UpdatePost(post); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordUpdate(post.Id, 1, before); err != nil {
+		t.Fatal(err)
+	}
+	post, _ = GetPost(*postId)
+	if post.Provenance.FilmRoll != "R34" || post.Provenance.ScannedOn != "2023-04-01" || post.Provenance.Resolution != 4000 {
+		t.Errorf("The provenance should be stored, got %v.", post.Provenance)
+	}
+
+	posts, _, _, err := ListPosts(10, 0, archiveFilter{Search: "r34"})
+	if err != nil || len(posts) != 1 || posts[0].Id != *postId {
+		t.Errorf("The archive should find posts by film roll: %v", err)
+	}
+
+	revisions, _ := ListRevisions(*postId)
+	if len(revisions) != 1 {
+		t.Fatalf("Expected one revision, got %d.", len(revisions))
+	}
+	found := false
+	for _, change := range revisions[0].Changes() {
+		found = found || change.Field == "provenance"
+	}
+	if !found {
+		t.Error("The revision should show the provenance change.")
+	}
+	if err := RevertPost(revisions[0], true, 1); err != nil {
+		t.Fatal(err)
+	}
+	post, _ = GetPost(*postId)
+	if post.Provenance.Known() {
+		t.Errorf("Reverting should remove the provenance again, got %v.", post.Provenance)
+	}
+
+	post.Provenance = Provenance{Scanner: "Epson V850"}
+	UpdatePost(post)
+	post.Latitude, post.Longitude, _ = parseLocation("51.5555", "5.0913")
+	UpdatePost(post)
+	provenance, _ := listProvenance([]int{*postId, *postId + 1})
+	collection := newFeatureCollection([]Post{post}, provenance)
+	if p := collection.Features[0].Properties.Provenance; p == nil || p.Scanner != "Epson V850" {
+		t.Errorf("The GeoJSON export should include the provenance, got %v.", p)
+	}
+	x := postXMP(post, nil, 0, 0)
+	if x.CreatorTool != "Epson V850" {
+		t.Errorf("The XMP export should include the scanner, got %q.", x.CreatorTool)
+	}
+}
+
+func TestParseRights(t *testing.T) {
+	form := url.Values{
+		"copyright_holder": {" Jan de Vries "},
+		"license":          {"CC-BY-SA-4.0"},
+		"credit":           {"Foto: Jan de Vries"},
+	}
+	rights, err := parseRights(form)
+	expected := Rights{Holder: "Jan de Vries", License: "CC-BY-SA-4.0", Credit: "Foto: Jan de Vries"}
+	if err != nil || rights != expected {
+		t.Errorf("Expected %v, got %v %v", expected, rights, err)
+	}
+	if _, err := parseRights(url.Values{"license": {"GPL-3.0"}}); err == nil {
+		t.Error("Unknown licenses should be rejected.")
+	}
+	if rights, _ := parseRights(url.Values{}); rights.Known() {
+		t.Error("An empty form should record no rights.")
+	}
+
+	tests := []struct {
+		rights Rights
+		year   int
+		notice string
+	}{
+		{Rights{Holder: "Jan de Vries", License: allRightsReserved}, 1965, "© 1965 Jan de Vries. All rights reserved."},
+		{Rights{Holder: "Jan de Vries", License: "CC-BY-4.0"}, 0, "© Jan de Vries, licensed under CC BY 4.0."},
+		{Rights{Holder: "Jan de Vries"}, 1965, "© 1965 Jan de Vries"},
+		{Rights{Holder: "Jan de Vries", License: "CC0-1.0"}, 1965, ""},
+		{Rights{License: allRightsReserved}, 1965, ""},
+	}
+	for _, test := range tests {
+		if notice := test.rights.Notice(test.year); notice != test.notice {
+			t.Errorf("Expected notice %q for %v, got %q", test.notice, test.rights, notice)
+		}
+	}
+}
+
+func TestRights(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	postId, _ := CreateDraft("image-0.jpg", dateOfYear(1965), 1)
+	post, _ := GetPost(*postId)
+	before := post.snapshot()
+	post.Rights = Rights{Holder: "Jan de Vries", License: "CC-BY-NC-4.0", Credit: "Foto: Jan de Vries", UsageNotes: "Not for advertising"}
+	if err := UpdatePost(post); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordUpdate(post.Id, 1, before); err != nil {
+		t.Fatal(err)
+	}
+	post, _ = GetPost(*postId)
+	if post.Rights.Holder != "Jan de Vries" || post.Rights.License != "CC-BY-NC-4.0" || post.Rights.UsageNotes != "Not for advertising" {
+		t.Errorf("The rights should be stored, got %v.", post.Rights)
+	}
+
+	x := rightsXMP(post)
+	if !x.Marked || x.WebStatement != "https://creativecommons.org/licenses/by-nc/4.0/" || x.Copyright != "© 1965 Jan de Vries, licensed under CC BY-NC 4.0." {
+		t.Errorf("The XMP should carry the rights, got %+v.", x)
+	}
+	post.Rights.License = "PDM-1.0"
+	if x := rightsXMP(post); x.Marked || x.Copyright != "" {
+		t.Errorf("Public domain photos should not be marked as copyrighted, got %+v.", x)
+	}
+
+	if _, err := getPostByObject("image-0.jpg", false); err != sql.ErrNoRows {
+		t.Errorf("Visitors should not get the image of a draft: %v", err)
+	}
+	found, err := getPostByObject("image-0.jpg", true)
+	if err != nil || found.Id != *postId || found.Rights.Credit != "Foto: Jan de Vries" {
+		t.Errorf("Editors should get the image with its rights, got %v %v", found.Rights, err)
+	}
+
+	revisions, _ := ListRevisions(*postId)
+	if len(revisions) != 1 {
+		t.Fatalf("Expected one revision, got %d.", len(revisions))
+	}
+	changed := false
+	for _, change := range revisions[0].Changes() {
+		changed = changed || change.Field == "rights"
+	}
+	if !changed {
+		t.Error("The revision should show the rights change.")
+	}
+	if err := RevertPost(revisions[0], true, 1); err != nil {
+		t.Fatal(err)
+	}
+	post, _ = GetPost(*postId)
+	if post.Rights.Known() {
+		t.Errorf("Reverting should remove the rights again, got %v.", post.Rights)
+	}
+}
+
+func TestDownloadHandler(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	postId, _ := CreateDraft("image-0.jpg", dateOfYear(1965), 1)
+	valid, _ := url.Parse(downloads.New(*postId, time.Hour).Path())
+	tampered := valid.Query()
+	tampered.Set("signature", "00"+tampered.Get("signature")[2:])
+	expired := downloads.New(*postId, -time.Hour).Path()
+	cases := []struct {
+		Description    string
+		Target         string
+		ExpectedStatus int
+	}{
+		{"malformed id", "/download/abc", http.StatusForbidden},
+		{"no signature", fmt.Sprintf("/download/%d", *postId), http.StatusForbidden},
+		{"tampered signature", fmt.Sprintf("/download/%d?%s", *postId, tampered.Encode()), http.StatusForbidden},
+		{"other post", fmt.Sprintf("/download/%d?%s", *postId+1, valid.RawQuery), http.StatusForbidden},
+		{"expired", expired, http.StatusGone},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.Target, nil)
+		w := httptest.NewRecorder()
+		DownloadHandler(w, req)
+		if w.Code != c.ExpectedStatus {
+			t.Errorf("Test '%s' failed because http response is '%d' instead of %d", c.Description, w.Code, c.ExpectedStatus)
+		}
+	}
+}
+
+func TestReviewHandlerOwnDraftsOnly(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	defer config.DB.Exec("TRUNCATE TABLE tags RESTART IDENTITY CASCADE;")
+	draftId, _ := CreateDraft("image-0", dateOfYear(1965), 1)
+	publishedId, _ := CreatePost("image-1", 1965, 1)
+	sessionID := uuid.NewV4().String()
+	users.SessionStore[sessionID] = 2
+	defer delete(users.SessionStore, sessionID)
+
+	for _, postId := range []int{*draftId, *publishedId} {
+		id := strconv.Itoa(postId)
+		form := url.Values{
+			"id":          {id},
+			"title-" + id: {"Overgenomen"},
+			"year-" + id:  {"1965"},
+			"tags-" + id:  {"gekaapt"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/review", nil)
+		req.PostForm = form
+		req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+		w := httptest.NewRecorder()
+		ReviewHandler(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Reviewing post %d of another user returned %d instead of %d", postId, w.Code, http.StatusForbidden)
+		}
+		post, _ := GetPost(postId)
+		if post.Title == "Overgenomen" || len(post.Tags) != 0 {
+			t.Errorf("Post %d should be left alone, got %q with tags %v", postId, post.Title, post.Tags)
+		}
+		if revisions, _ := ListRevisions(postId); len(revisions) != 0 {
+			t.Errorf("No revision should be recorded for post %d, got %d", postId, len(revisions))
+		}
+	}
+
+	post, _ := GetPost(*publishedId)
+	if err := SaveDraft(post, true); err == nil {
+		t.Error("Saving a published post as a draft should fail.")
+	}
+	post, _ = GetPost(*draftId)
+	post.UserId = 2
+	if err := SaveDraft(post, true); err == nil {
+		t.Error("Saving the draft of another user should fail.")
+	}
+}
+
+func TestPurgeSharedObject(t *testing.T) {
+	users.CreateTestUser()
+	defer config.DB.Exec("TRUNCATE TABLE users RESTART IDENTITY CASCADE;")
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		t.Fatalf("error starting MinIO client: %v", err)
+	}
+	objectName := "1965/shared-object.jpg"
+	content := []byte("shared")
+	_, err = minioClient.PutObject(objects.Bucket, objectName, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatalf("error storing object in MinIO: %v", err)
+	}
+	defer minioClient.RemoveObject(objects.Bucket, objectName)
+	first, _ := CreatePost(objectName, 1965, 1)
+	second, _ := CreatePost(objectName, 1965, 1)
+
+	DeletePost(*first, 1)
+	if err := PurgePost(*first); err != nil {
+		t.Fatalf("Post could not be purged: %v", err)
+	}
+	if _, err := minioClient.StatObject(objects.Bucket, objectName, minio.StatObjectOptions{}); err != nil {
+		t.Errorf("The object of the remaining post should be kept: %v", err)
+	}
+	DeletePost(*second, 1)
+	if purged, err := purgeExpired(-time.Hour); err != nil || purged != 1 {
+		t.Fatalf("The second post should be purged: %v", err)
+	}
+	if _, err := minioClient.StatObject(objects.Bucket, objectName, minio.StatObjectOptions{}); err == nil {
+		t.Error("The object should be removed with the last post showing it.")
+	}
+}
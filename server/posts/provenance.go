@@ -0,0 +1,148 @@
+package posts
+
+import (
+	"fmt"
+	"net/url"
+	"project/server/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Provenance records where the negative of a post is kept and how it was
+// digitized. Every field is optional.
+type Provenance struct {
+	FilmRoll       string `json:"filmRoll,omitempty"`
+	NegativeNumber string `json:"negativeNumber,omitempty"`
+	// Box is where the negative is kept in the physical archive.
+	Box     string `json:"box,omitempty"`
+	Scanner string `json:"scanner,omitempty"`
+	// ScannedOn is the scan date as 2006-01-02, empty when unknown.
+	ScannedOn string `json:"scannedOn,omitempty"`
+	// Resolution is the scan resolution in dots per inch, zero when unknown.
+	Resolution int `json:"resolution,omitempty"`
+}
+
+// Known reports whether any of the provenance is recorded.
+func (p Provenance) Known() bool {
+	return p != Provenance{}
+}
+
+// Reference locates the negative in the physical archive.
+func (p Provenance) Reference() string {
+	var parts []string
+	if p.Box != "" {
+		parts = append(parts, "box "+p.Box)
+	}
+	if p.FilmRoll != "" {
+		parts = append(parts, "roll "+p.FilmRoll)
+	}
+	if p.NegativeNumber != "" {
+		parts = append(parts, "negative "+p.NegativeNumber)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String summarises the provenance for the revision history.
+func (p Provenance) String() string {
+	parts := []string{}
+	if reference := p.Reference(); reference != "" {
+		parts = append(parts, reference)
+	}
+	if p.Scanner != "" {
+		parts = append(parts, "scanner "+p.Scanner)
+	}
+	if p.ScannedOn != "" {
+		parts = append(parts, "scanned "+p.ScannedOn)
+	}
+	if p.Resolution != 0 {
+		parts = append(parts, fmt.Sprintf("%d dpi", p.Resolution))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseProvenance reads the provenance fields of the update form.
+func parseProvenance(form url.Values) (Provenance, error) {
+	p := Provenance{
+		FilmRoll:       strings.TrimSpace(form.Get("film_roll")),
+		NegativeNumber: strings.TrimSpace(form.Get("negative_number")),
+		Box:            strings.TrimSpace(form.Get("box")),
+		Scanner:        strings.TrimSpace(form.Get("scanner")),
+		ScannedOn:      strings.TrimSpace(form.Get("scanned_on")),
+	}
+	if p.ScannedOn != "" {
+		scannedOn, err := time.Parse("2006-01-02", p.ScannedOn)
+		if err != nil {
+			return p, fmt.Errorf("scan date %q is not a date", p.ScannedOn)
+		}
+		if scannedOn.After(time.Now()) {
+			return p, fmt.Errorf("scan date %s lies in the future", p.ScannedOn)
+		}
+	}
+	if value := strings.TrimSpace(form.Get("scan_resolution")); value != "" {
+		resolution, err := strconv.Atoi(value)
+		if err != nil || resolution <= 0 {
+			return p, fmt.Errorf("scan resolution %q is not a positive number", value)
+		}
+		p.Resolution = resolution
+	}
+	return p, nil
+}
+
+// provenanceColumns lists the post_provenance columns in the order
+// listProvenance scans them, with NULL dates and resolutions as zero values.
+const provenanceColumns = `film_roll, negative_number, box, scanner, COALESCE(TO_CHAR(scanned_on, 'YYYY-MM-DD'), ''), COALESCE(resolution, 0)`
+
+// getProvenance returns the provenance of a post, empty when none was
+// recorded.
+func getProvenance(postId int) (Provenance, error) {
+	provenance, err := listProvenance([]int{postId})
+	return provenance[postId], err
+}
+
+// listProvenance returns the recorded provenance of the posts by post id.
+func listProvenance(postIds []int) (map[int]Provenance, error) {
+	provenance := make(map[int]Provenance)
+	rows, err := config.DB.Query(`
+		SELECT post_id, `+provenanceColumns+`
+		FROM post_provenance
+		WHERE post_id = ANY($1);
+		`, pq.Array(postIds))
+	if err != nil {
+		return provenance, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postId int
+		var p Provenance
+		err := rows.Scan(&postId, &p.FilmRoll, &p.NegativeNumber, &p.Box, &p.Scanner, &p.ScannedOn, &p.Resolution)
+		if err != nil {
+			return provenance, err
+		}
+		provenance[postId] = p
+	}
+	return provenance, rows.Err()
+}
+
+// saveProvenance stores the provenance of a post, removing the record when
+// nothing is known.
+func saveProvenance(postId int, p Provenance) error {
+	if !p.Known() {
+		_, err := config.DB.Exec("DELETE FROM post_provenance WHERE post_id=$1;", postId)
+		return err
+	}
+	_, err := config.DB.Exec(`
+		INSERT INTO post_provenance (POST_ID, FILM_ROLL, NEGATIVE_NUMBER, BOX, SCANNER, SCANNED_ON, RESOLUTION)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::DATE, NULLIF($7, 0))
+		ON CONFLICT (POST_ID) DO UPDATE SET
+			FILM_ROLL = EXCLUDED.FILM_ROLL,
+			NEGATIVE_NUMBER = EXCLUDED.NEGATIVE_NUMBER,
+			BOX = EXCLUDED.BOX,
+			SCANNER = EXCLUDED.SCANNER,
+			SCANNED_ON = EXCLUDED.SCANNED_ON,
+			RESOLUTION = EXCLUDED.RESOLUTION;`,
+		postId, p.FilmRoll, p.NegativeNumber, p.Box, p.Scanner, p.ScannedOn, p.Resolution)
+	return err
+}
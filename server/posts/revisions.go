@@ -0,0 +1,237 @@
+package posts
+
+import (
+	"encoding/json"
+	"fmt"
+	"project/server/config"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshot is the part of a post that is tracked in its revision history.
+// Revisions store it as JSON, so fields can be added without a migration.
+// Fields added later are pointers: they are nil in older revisions, and
+// reverting to those leaves the current value alone.
+type snapshot struct {
+	Title       string
+	Description string
+	Year        int
+	// Date is empty in revisions recorded before posts had a fuzzy date.
+	Date string `json:",omitempty"`
+	Tags []string
+	// Location is "latitude,longitude", empty for a post that is not placed.
+	Location   *string     `json:",omitempty"`
+	PlaceName  *string     `json:",omitempty"`
+	Provenance *Provenance `json:",omitempty"`
+	Rights     *Rights     `json:",omitempty"`
+}
+
+type Revision struct {
+	Id        int
+	PostId    int
+	UserId    int
+	UserName  string
+	CreatedAt time.Time
+	Before    snapshot
+	After     snapshot
+}
+
+type fieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+func (post Post) snapshot() snapshot {
+	tags := make([]string, len(post.Tags))
+	copy(tags, post.Tags)
+	sort.Strings(tags)
+	location := post.Coordinates()
+	placeName := post.PlaceName
+	provenance := post.Provenance
+	rights := post.Rights
+	return snapshot{
+		Title:       post.Title,
+		Description: post.Description,
+		Year:        post.Year,
+		Date:        post.Date.String(),
+		Tags:        tags,
+		Location:    &location,
+		PlaceName:   &placeName,
+		Provenance:  &provenance,
+		Rights:      &rights,
+	}
+}
+
+// apply copies the tracked fields of s onto post, except those the revision
+// was recorded without.
+func (s snapshot) apply(post Post) Post {
+	post.Title = s.Title
+	post.Description = s.Description
+	post.Year = s.Year
+	post.Date = dateOfYear(s.Year)
+	if date, err := parseFuzzyDate(s.Date); err == nil {
+		post.Date = date
+	}
+	post.Tags = s.Tags
+	if s.Location != nil {
+		post.Latitude, post.Longitude, _ = parseCoordinates(*s.Location)
+	}
+	if s.PlaceName != nil {
+		post.PlaceName = *s.PlaceName
+	}
+	if s.Provenance != nil {
+		post.Provenance = *s.Provenance
+	}
+	if s.Rights != nil {
+		post.Rights = *s.Rights
+	}
+	return post
+}
+
+// Changes lists the fields that differ between the before and after state.
+func (r Revision) Changes() []fieldChange {
+	var changes []fieldChange
+	if r.Before.Title != r.After.Title {
+		changes = append(changes, fieldChange{"title", r.Before.Title, r.After.Title})
+	}
+	if r.Before.Description != r.After.Description {
+		changes = append(changes, fieldChange{"description", r.Before.Description, r.After.Description})
+	}
+	if r.Before.Date != r.After.Date && r.Before.Date != "" && r.After.Date != "" {
+		changes = append(changes, fieldChange{"date", r.Before.Date, r.After.Date})
+	} else if r.Before.Year != r.After.Year {
+		changes = append(changes, fieldChange{"year", strconv.Itoa(r.Before.Year), strconv.Itoa(r.After.Year)})
+	}
+	if !reflect.DeepEqual(r.Before.Tags, r.After.Tags) {
+		changes = append(changes, fieldChange{"tags", strings.Join(r.Before.Tags, ", "), strings.Join(r.After.Tags, ", ")})
+	}
+	if optional(r.Before.Location) != optional(r.After.Location) {
+		changes = append(changes, fieldChange{"location", optional(r.Before.Location), optional(r.After.Location)})
+	}
+	if optional(r.Before.PlaceName) != optional(r.After.PlaceName) {
+		changes = append(changes, fieldChange{"place", optional(r.Before.PlaceName), optional(r.After.PlaceName)})
+	}
+	if optional(r.Before.Provenance) != optional(r.After.Provenance) {
+		changes = append(changes, fieldChange{"provenance", optional(r.Before.Provenance).String(), optional(r.After.Provenance).String()})
+	}
+	if optional(r.Before.Rights) != optional(r.After.Rights) {
+		changes = append(changes, fieldChange{"rights", optional(r.Before.Rights).String(), optional(r.After.Rights).String()})
+	}
+	return changes
+}
+
+// optional is the value of a snapshot field, empty when the revision was
+// recorded without it.
+func optional[T any](value *T) T {
+	var empty T
+	if value == nil {
+		return empty
+	}
+	return *value
+}
+
+// recordRevision stores a change to a post's metadata. Saving without changes
+// does not create a revision.
+func recordRevision(postId, userId int, before, after snapshot) error {
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	_, err = config.DB.Exec(`
+		INSERT INTO revisions (POST_ID, USER_ID, BEFORE, AFTER) VALUES ($1, $2, $3, $4);`,
+		postId, userId, beforeJSON, afterJSON)
+	return err
+}
+
+func scanRevision(row scanner) (Revision, error) {
+	revision := Revision{}
+	var before, after []byte
+	err := row.Scan(&revision.Id, &revision.PostId, &revision.UserId, &revision.UserName, &revision.CreatedAt, &before, &after)
+	if err != nil {
+		return revision, err
+	}
+	if err := json.Unmarshal(before, &revision.Before); err != nil {
+		return revision, err
+	}
+	err = json.Unmarshal(after, &revision.After)
+	return revision, err
+}
+
+// ListRevisions returns the history of a post, newest first.
+func ListRevisions(postId int) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	rows, err := config.DB.Query(`
+		SELECT r.id, r.post_id, r.user_id, u.name, r.created_at, r.before, r.after
+		FROM revisions r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.post_id = $1
+		ORDER BY r.id DESC;
+		`, postId)
+	if err != nil {
+		return revisions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return revisions, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func GetRevision(revisionId int) (Revision, error) {
+	row := config.DB.QueryRow(`
+		SELECT r.id, r.post_id, r.user_id, u.name, r.created_at, r.before, r.after
+		FROM revisions r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.id = $1;
+		`, revisionId)
+	return scanRevision(row)
+}
+
+// RevertPost restores the metadata of a post to the state before or after
+// the given revision. The revert itself is recorded as a new revision.
+func RevertPost(revision Revision, toBefore bool, userId int) error {
+	post, err := GetPost(revision.PostId)
+	if err != nil {
+		return err
+	}
+	target := revision.After
+	if toBefore {
+		target = revision.Before
+	}
+	before := post.snapshot()
+	post = target.apply(post)
+	err = UpdatePost(post)
+	if err != nil {
+		return fmt.Errorf("error updating post: %v", err)
+	}
+	err = UpdateTags(&post.Id, post.Tags)
+	if err != nil {
+		return fmt.Errorf("error updating tags: %v", err)
+	}
+	return recordUpdate(post.Id, userId, before)
+}
+
+// recordUpdate reloads a post after it was saved and records the difference
+// with its earlier state.
+func recordUpdate(postId, userId int, before snapshot) error {
+	post, err := GetPost(postId)
+	if err != nil {
+		return err
+	}
+	return recordRevision(postId, userId, before, post.snapshot())
+}
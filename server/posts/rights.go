@@ -0,0 +1,171 @@
+package posts
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"project/server/config"
+	"project/server/imagemeta"
+	"strings"
+)
+
+// license is a way a photo may be used, identified by its SPDX code.
+type license struct {
+	Code  string
+	Label string
+	URL   string
+	// Copyrighted is false for photos in the public domain.
+	Copyrighted bool
+}
+
+const allRightsReserved = "all-rights-reserved"
+
+var licenses = []license{
+	{allRightsReserved, "All rights reserved", "", true},
+	{"CC-BY-4.0", "CC BY 4.0", "https://creativecommons.org/licenses/by/4.0/", true},
+	{"CC-BY-SA-4.0", "CC BY-SA 4.0", "https://creativecommons.org/licenses/by-sa/4.0/", true},
+	{"CC-BY-ND-4.0", "CC BY-ND 4.0", "https://creativecommons.org/licenses/by-nd/4.0/", true},
+	{"CC-BY-NC-4.0", "CC BY-NC 4.0", "https://creativecommons.org/licenses/by-nc/4.0/", true},
+	{"CC-BY-NC-SA-4.0", "CC BY-NC-SA 4.0", "https://creativecommons.org/licenses/by-nc-sa/4.0/", true},
+	{"CC-BY-NC-ND-4.0", "CC BY-NC-ND 4.0", "https://creativecommons.org/licenses/by-nc-nd/4.0/", true},
+	{"CC0-1.0", "CC0 1.0", "https://creativecommons.org/publicdomain/zero/1.0/", false},
+	{"PDM-1.0", "Public Domain Mark 1.0", "https://creativecommons.org/publicdomain/mark/1.0/", false},
+}
+
+func findLicense(code string) (license, bool) {
+	for _, l := range licenses {
+		if l.Code == code {
+			return l, true
+		}
+	}
+	return license{}, false
+}
+
+// Rights records who owns a photo and how it may be used. Every field is
+// optional.
+type Rights struct {
+	Holder string
+	// License is the code of one of the licenses.
+	License string
+	// Credit is the line to print with the photo.
+	Credit     string
+	UsageNotes string
+}
+
+// Known reports whether any of the rights are recorded.
+func (r Rights) Known() bool {
+	return r != Rights{}
+}
+
+func (r Rights) LicenseLabel() string {
+	l, _ := findLicense(r.License)
+	return l.Label
+}
+
+func (r Rights) LicenseURL() string {
+	l, _ := findLicense(r.License)
+	return l.URL
+}
+
+// Notice is the copyright notice, such as "© 1965 Jan de Vries. All rights
+// reserved." It is empty for photos in the public domain.
+func (r Rights) Notice(year int) string {
+	l, ok := findLicense(r.License)
+	if r.Holder == "" || (ok && !l.Copyrighted) {
+		return ""
+	}
+	notice := "© "
+	if year > 0 {
+		notice += fmt.Sprintf("%d ", year)
+	}
+	notice += r.Holder
+	switch {
+	case r.License == allRightsReserved:
+		notice += ". All rights reserved."
+	case ok:
+		notice += ", licensed under " + l.Label + "."
+	}
+	return notice
+}
+
+// String summarises the rights for the revision history.
+func (r Rights) String() string {
+	var parts []string
+	if r.Holder != "" {
+		parts = append(parts, "© "+r.Holder)
+	}
+	if label := r.LicenseLabel(); label != "" {
+		parts = append(parts, label)
+	}
+	if r.Credit != "" {
+		parts = append(parts, "credit "+r.Credit)
+	}
+	if r.UsageNotes != "" {
+		parts = append(parts, r.UsageNotes)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseRights reads the rights fields of the update form.
+func parseRights(form url.Values) (Rights, error) {
+	r := Rights{
+		Holder:     strings.TrimSpace(form.Get("copyright_holder")),
+		License:    strings.TrimSpace(form.Get("license")),
+		Credit:     strings.TrimSpace(form.Get("credit")),
+		UsageNotes: strings.TrimSpace(form.Get("usage_notes")),
+	}
+	if _, ok := findLicense(r.License); r.License != "" && !ok {
+		return r, fmt.Errorf("unknown license %q", r.License)
+	}
+	return r, nil
+}
+
+// getRights returns the rights of a post, empty when none were recorded.
+func getRights(postId int) (Rights, error) {
+	var r Rights
+	err := config.DB.QueryRow(`
+		SELECT holder, license, credit, usage_notes FROM post_rights WHERE post_id=$1;
+		`, postId).Scan(&r.Holder, &r.License, &r.Credit, &r.UsageNotes)
+	if err == sql.ErrNoRows {
+		return r, nil
+	}
+	return r, err
+}
+
+// saveRights stores the rights of a post, removing the record when nothing
+// is known.
+func saveRights(postId int, r Rights) error {
+	if !r.Known() {
+		_, err := config.DB.Exec("DELETE FROM post_rights WHERE post_id=$1;", postId)
+		return err
+	}
+	_, err := config.DB.Exec(`
+		INSERT INTO post_rights (POST_ID, HOLDER, LICENSE, CREDIT, USAGE_NOTES)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (POST_ID) DO UPDATE SET
+			HOLDER = EXCLUDED.HOLDER,
+			LICENSE = EXCLUDED.LICENSE,
+			CREDIT = EXCLUDED.CREDIT,
+			USAGE_NOTES = EXCLUDED.USAGE_NOTES;`,
+		postId, r.Holder, r.License, r.Credit, r.UsageNotes)
+	return err
+}
+
+// rightsXMP is the metadata embedded in the served images: what the photo
+// shows and who owns it.
+func rightsXMP(post Post) imagemeta.XMP {
+	l, _ := findLicense(post.Rights.License)
+	usageTerms := post.Rights.UsageNotes
+	if usageTerms == "" {
+		usageTerms = l.Label
+	}
+	return imagemeta.XMP{
+		Title:        post.Title,
+		Description:  post.Description,
+		Copyright:    post.Rights.Notice(post.Date.Year()),
+		UsageTerms:   usageTerms,
+		WebStatement: l.URL,
+		Marked:       l.Copyrighted || (post.Rights.Holder != "" && l.Code == ""),
+		Credit:       post.Rights.Credit,
+	}
+}
@@ -0,0 +1,662 @@
+package posts
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/server/config"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	TagPerson  = "person"
+	TagPlace   = "place"
+	TagEvent   = "event"
+	TagSubject = "subject"
+)
+
+// tagTypes lists the tag types in the order they are shown on the index.
+var tagTypes = []string{TagPerson, TagPlace, TagEvent, TagSubject}
+
+var tagTypeLabels = map[string]string{
+	TagPerson:  "Personen",
+	TagPlace:   "Plaatsen",
+	TagEvent:   "Gebeurtenissen",
+	TagSubject: "Thema's",
+}
+
+type Tag struct {
+	Id       int
+	Name     string
+	Type     string
+	ParentId sql.NullInt64
+	Aliases  []string
+	Count    int
+	// Description and DisplayOrder are shown on and order the index page.
+	Description  string
+	DisplayOrder int
+	CoverPostId  sql.NullInt64
+	CoverURL     string
+}
+
+// tagRep is the tile of a tag on the index page.
+type tagRep struct {
+	Name        string
+	Type        string
+	Description string
+	PostId      int
+	ImageURL    string
+}
+
+// tagGroup holds the representatives of every tag of one type.
+type tagGroup struct {
+	Type  string
+	Label string
+	Reps  []tagRep
+}
+
+func validTagType(tagType string) bool {
+	for _, t := range tagTypes {
+		if t == tagType {
+			return true
+		}
+	}
+	return false
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// tagTreeCondition matches posts tagged with any of the tag names or aliases
+// in argument $n or with one of their descendants.
+func tagTreeCondition(n int) string {
+	return fmt.Sprintf(`p.id IN (
+			SELECT tagmap.post_id
+			FROM tagmap
+			WHERE tagmap.tag_id IN (
+				WITH RECURSIVE subtags(id) AS (
+					SELECT id FROM tags
+					WHERE name = ANY($%[1]d)
+					OR id IN (SELECT tag_id FROM tag_aliases WHERE alias = ANY($%[1]d))
+					UNION
+					SELECT tags.id FROM tags JOIN subtags ON tags.parent_id = subtags.id
+				)
+				SELECT id FROM subtags
+			)
+		)`, n)
+}
+
+// searchCondition matches posts whose title, description, tags or physical
+// archive reference contain the LIKE pattern in argument $n, or that are
+// tagged with the alias in $n+1.
+func searchCondition(n int) string {
+	return fmt.Sprintf(`(p.title ILIKE $%[1]d
+			OR p.description ILIKE $%[1]d
+			OR p.id IN (
+				SELECT tagmap.post_id
+				FROM tagmap
+				JOIN tags ON tags.id = tagmap.tag_id
+				WHERE tags.name ILIKE $%[1]d
+				OR tags.id IN (SELECT tag_id FROM tag_aliases WHERE alias = $%[2]d)
+			)
+			OR p.id IN (
+				SELECT post_id
+				FROM post_provenance
+				WHERE film_roll ILIKE $%[1]d
+				OR negative_number ILIKE $%[1]d
+				OR box ILIKE $%[1]d
+				OR scanner ILIKE $%[1]d
+			))`, n, n+1)
+}
+
+// resolveAlias returns the canonical tag name for an alias, or the name
+// itself when it is not an alias.
+func resolveAlias(name string) (string, error) {
+	var canonical string
+	err := config.DB.QueryRow(`
+		SELECT t.name FROM tag_aliases a JOIN tags t ON t.id = a.tag_id WHERE a.alias = $1;
+		`, name).Scan(&canonical)
+	if err == sql.ErrNoRows {
+		return name, nil
+	}
+	return canonical, err
+}
+
+// TagAudit records a change made on the tag admin page.
+type TagAudit struct {
+	Id        int
+	UserName  string
+	Action    string
+	Tag       string
+	Target    string
+	CreatedAt time.Time
+}
+
+// ListTags returns every tag with the number of posts using it.
+func ListTags() ([]Tag, error) {
+	tags := make([]Tag, 0)
+	rows, err := config.DB.Query(`
+		SELECT t.id, t.name, t.type, t.parent_id,
+			ARRAY(SELECT alias FROM tag_aliases WHERE tag_id = t.id ORDER BY alias),
+			COUNT(tm.post_id), t.description, t.display_order, t.cover_post_id,
+			COALESCE(c.minio_url, '')
+		FROM tags t
+		LEFT JOIN tagmap tm ON tm.tag_id = t.id
+		LEFT JOIN posts c ON c.id = t.cover_post_id
+		GROUP BY t.id, c.id
+		ORDER BY t.name;
+		`)
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tag := Tag{}
+		err := rows.Scan(&tag.Id, &tag.Name, &tag.Type, &tag.ParentId, pq.Array(&tag.Aliases), &tag.Count,
+			&tag.Description, &tag.DisplayOrder, &tag.CoverPostId, &tag.CoverURL)
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func getTagName(tx *sql.Tx, tagId int) (string, error) {
+	var name string
+	err := tx.QueryRow("SELECT name FROM tags WHERE id=$1;", tagId).Scan(&name)
+	return name, err
+}
+
+func auditTag(tx *sql.Tx, userId int, action, tag, target string) error {
+	_, err := tx.Exec(`
+		INSERT INTO tag_audit (USER_ID, ACTION, TAG, TARGET) VALUES ($1, $2, $3, $4);`,
+		userId, action, tag, target)
+	return err
+}
+
+// errTagExists is returned when a tag is renamed to a name another tag got
+// while it was being renamed.
+var errTagExists = errors.New("another tag already has this name")
+
+// RenameTag gives a tag a new name. Renaming to the name of another tag
+// merges the two.
+func RenameTag(tagId int, name string, userId int) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return fmt.Errorf("tag name is empty")
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var targetId int
+	err = tx.QueryRow("SELECT id FROM tags WHERE name=$1 FOR UPDATE;", name).Scan(&targetId)
+	if err == nil && targetId != tagId {
+		if err := mergeTags(tx, tagId, targetId, userId); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	oldName, err := getTagName(tx, tagId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE tags SET name=$1 WHERE id=$2;", name, tagId)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return errTagExists
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tag_aliases WHERE alias=$1;", name)
+	if err != nil {
+		return err
+	}
+	err = auditTag(tx, userId, "rename", oldName, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MergeTags moves every post of source to target, skipping posts that
+// already have target, and removes source. The name of source and its
+// aliases become aliases of target, and its children move below target.
+func MergeTags(sourceId, targetId int, userId int) error {
+	if sourceId == targetId {
+		return fmt.Errorf("cannot merge a tag into itself")
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := mergeTags(tx, sourceId, targetId, userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// mergeTags does the work of MergeTags in tx, so a rename can merge in the
+// transaction it checked the name in.
+func mergeTags(tx *sql.Tx, sourceId, targetId int, userId int) error {
+	sourceName, err := getTagName(tx, sourceId)
+	if err != nil {
+		return err
+	}
+	targetName, err := getTagName(tx, targetId)
+	if err != nil {
+		return err
+	}
+	// a target below the source first takes the place of the source, so the
+	// children it receives can not end up above it
+	below, err := isDescendant(tx, targetId, sourceId)
+	if err != nil {
+		return err
+	}
+	if below {
+		_, err = tx.Exec(`
+			UPDATE tags SET parent_id = (SELECT parent_id FROM tags WHERE id = $1)
+			WHERE id = $2;
+			`, sourceId, targetId)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE tags SET parent_id=$2 WHERE parent_id=$1;", sourceId, targetId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO tagmap (post_id, tag_id)
+		SELECT DISTINCT s.post_id, $2::integer FROM tagmap s
+		WHERE s.tag_id = $1
+		AND NOT EXISTS (SELECT 1 FROM tagmap t WHERE t.post_id = s.post_id AND t.tag_id = $2);
+		`, sourceId, targetId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tagmap WHERE tag_id=$1;", sourceId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE tag_aliases SET tag_id=$2 WHERE tag_id=$1;", sourceId, targetId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE id=$1;", sourceId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO tag_aliases (alias, tag_id) VALUES ($1, $2)
+		ON CONFLICT (alias) DO UPDATE SET tag_id = EXCLUDED.tag_id;
+		`, sourceName, targetId)
+	if err != nil {
+		return err
+	}
+	return auditTag(tx, userId, "merge", sourceName, targetName)
+}
+
+// DeleteTag removes a tag from every post and deletes it.
+func DeleteTag(tagId int, userId int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	name, err := getTagName(tx, tagId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE tags SET parent_id = (SELECT parent_id FROM tags WHERE id = $1)
+		WHERE parent_id = $1;
+		`, tagId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tagmap WHERE tag_id=$1;", tagId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tags WHERE id=$1;", tagId)
+	if err != nil {
+		return err
+	}
+	err = auditTag(tx, userId, "delete", name, "")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddTagAlias makes alias resolve to the tag. An alias cannot be the name of
+// an existing tag; merge the tags instead.
+func AddTagAlias(tagId int, alias string, userId int) error {
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	if alias == "" {
+		return fmt.Errorf("alias is empty")
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	name, err := getTagName(tx, tagId)
+	if err != nil {
+		return err
+	}
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM tags WHERE name=$1);", alias).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("alias %s is already a tag", alias)
+	}
+	_, err = tx.Exec(`
+		INSERT INTO tag_aliases (alias, tag_id) VALUES ($1, $2)
+		ON CONFLICT (alias) DO UPDATE SET tag_id = EXCLUDED.tag_id;
+		`, alias, tagId)
+	if err != nil {
+		return err
+	}
+	err = auditTag(tx, userId, "alias", alias, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveTagAlias deletes an alias of the tag.
+func RemoveTagAlias(tagId int, alias string, userId int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	name, err := getTagName(tx, tagId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tag_aliases WHERE alias=$1 AND tag_id=$2;", alias, tagId)
+	if err != nil {
+		return err
+	}
+	err = auditTag(tx, userId, "unalias", alias, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isDescendant reports whether tagId is ancestorId itself or lies below it.
+func isDescendant(tx *sql.Tx, tagId, ancestorId int) (bool, error) {
+	var descendant bool
+	err := tx.QueryRow(`
+		WITH RECURSIVE subtags(id) AS (
+			SELECT id FROM tags WHERE id = $1
+			UNION
+			SELECT tags.id FROM tags JOIN subtags ON tags.parent_id = subtags.id
+		)
+		SELECT EXISTS (SELECT 1 FROM subtags WHERE id = $2);
+		`, ancestorId, tagId).Scan(&descendant)
+	return descendant, err
+}
+
+// ClassifyTag sets the type and parent of a tag. A parent of 0 makes it a
+// top level tag; a tag cannot be placed below one of its own descendants.
+func ClassifyTag(tagId int, tagType string, parentId int, userId int) error {
+	if !validTagType(tagType) {
+		return fmt.Errorf("invalid tag type: %s", tagType)
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	name, err := getTagName(tx, tagId)
+	if err != nil {
+		return err
+	}
+	parent := sql.NullInt64{}
+	parentName := ""
+	if parentId != 0 {
+		cycle, err := isDescendant(tx, parentId, tagId)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return fmt.Errorf("tag %s cannot be placed below its own descendant", name)
+		}
+		parentName, err = getTagName(tx, parentId)
+		if err != nil {
+			return err
+		}
+		parent = sql.NullInt64{Int64: int64(parentId), Valid: true}
+	}
+	_, err = tx.Exec("UPDATE tags SET type=$1, parent_id=$2 WHERE id=$3;", tagType, parent, tagId)
+	if err != nil {
+		return err
+	}
+	err = auditTag(tx, userId, "classify "+tagType, name, parentName)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// groupTagReps groups the tag representatives by tag type, leaving out
+// empty groups.
+func groupTagReps(reps []tagRep) []tagGroup {
+	byType := make(map[string][]tagRep)
+	for _, rep := range reps {
+		byType[rep.Type] = append(byType[rep.Type], rep)
+	}
+	groups := make([]tagGroup, 0, len(tagTypes))
+	for _, tagType := range tagTypes {
+		if len(byType[tagType]) == 0 {
+			continue
+		}
+		groups = append(groups, tagGroup{
+			Type:  tagType,
+			Label: tagTypeLabels[tagType],
+			Reps:  byType[tagType],
+		})
+	}
+	return groups
+}
+
+// CurateTag sets the description, display order and cover post of a tag. A
+// cover of 0 falls back to the latest post of the tag.
+func CurateTag(tagId int, description string, displayOrder, coverPostId int, userId int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	name, err := getTagName(tx, tagId)
+	if err != nil {
+		return err
+	}
+	cover := sql.NullInt64{Int64: int64(coverPostId), Valid: coverPostId != 0}
+	_, err = tx.Exec(`
+		UPDATE tags SET description=$1, display_order=$2, cover_post_id=$3 WHERE id=$4;
+		`, strings.TrimSpace(description), displayOrder, cover, tagId)
+	if err != nil {
+		return err
+	}
+	err = auditTag(tx, userId, "curate", name, "")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetTagCover pins a post as the cover of the tag with the given name.
+func SetTagCover(name string, postId int, userId int) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("UPDATE tags SET cover_post_id=$1 WHERE name=$2;", postId, name)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return fmt.Errorf("tag %s does not exist", name)
+	}
+	err = auditTag(tx, userId, "cover", name, strconv.Itoa(postId))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// tagSuggestion is an existing tag offered while an editor types a tag.
+type tagSuggestion struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	// Alias is set when the tag was matched through one of its aliases.
+	Alias string `json:"alias,omitempty"`
+	rank  int
+}
+
+// Suggestion ranks, best first.
+const (
+	rankPrefix = iota
+	rankSubstring
+	rankAlias
+	rankFuzzy
+)
+
+// SuggestTags returns at most limit existing tags matching query: prefix
+// matches first, then substring, alias and fuzzy matches, each ranked by
+// usage. An empty query returns the most used tags.
+func SuggestTags(query string, limit int) ([]tagSuggestion, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	tags, err := ListTags()
+	if err != nil {
+		return nil, err
+	}
+	suggestions := make([]tagSuggestion, 0)
+	for _, tag := range tags {
+		suggestion := tagSuggestion{Name: tag.Name, Type: tag.Type, Count: tag.Count}
+		if rank, alias, ok := matchTag(tag, query); ok {
+			suggestion.rank = rank
+			suggestion.Alias = alias
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+func matchTag(tag Tag, query string) (int, string, bool) {
+	switch {
+	case strings.HasPrefix(tag.Name, query):
+		return rankPrefix, "", true
+	case strings.Contains(tag.Name, query):
+		return rankSubstring, "", true
+	}
+	for _, alias := range tag.Aliases {
+		if strings.Contains(alias, query) {
+			return rankAlias, alias, true
+		}
+	}
+	// allow one typo for every four characters typed
+	maxDistance := len([]rune(query)) / 4
+	if maxDistance == 0 {
+		return 0, "", false
+	}
+	words := append([]string{tag.Name}, strings.Fields(tag.Name)...)
+	for _, word := range words {
+		if levenshtein(word, query) <= maxDistance {
+			return rankFuzzy, "", true
+		}
+		// compare against the start of the word, the editor may not be done typing
+		if runes := []rune(word); len(runes) > len([]rune(query)) {
+			if levenshtein(string(runes[:len([]rune(query))]), query) <= maxDistance {
+				return rankFuzzy, "", true
+			}
+		}
+	}
+	return 0, "", false
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(t)]
+}
+
+// ListTagAudit returns the most recent changes to tags.
+func ListTagAudit(limit int) ([]TagAudit, error) {
+	audit := make([]TagAudit, 0)
+	rows, err := config.DB.Query(`
+		SELECT a.id, u.name, a.action, a.tag, a.target, a.created_at
+		FROM tag_audit a
+		JOIN users u ON u.id = a.user_id
+		ORDER BY a.id DESC
+		LIMIT $1;
+		`, limit)
+	if err != nil {
+		return audit, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		entry := TagAudit{}
+		err := rows.Scan(&entry.Id, &entry.UserName, &entry.Action, &entry.Tag, &entry.Target, &entry.CreatedAt)
+		if err != nil {
+			return audit, err
+		}
+		audit = append(audit, entry)
+	}
+	return audit, rows.Err()
+}
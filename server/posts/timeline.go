@@ -0,0 +1,76 @@
+package posts
+
+import (
+	"fmt"
+	"project/server/config"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// thumbnailsPerYear is how many photos the timeline shows for every year.
+const thumbnailsPerYear = 4
+
+type thumbnail struct {
+	Id       int
+	ImageURL string
+}
+
+type timelineYear struct {
+	Year       int
+	Count      int
+	Thumbnails []thumbnail
+}
+
+type timelineDecade struct {
+	Start int
+	Count int
+	Years []timelineYear
+}
+
+// listTimeline counts the visible posts per year, optionally of a tag and its
+// descendants, and groups the years by decade. Undated posts are left out.
+func listTimeline(tag string, showAll bool) ([]timelineDecade, error) {
+	conditions := []string{VisibleCondition(showAll), "p.year > 0"}
+	var args []any
+	if tag != "" {
+		args = append(args, pq.Array([]string{tag}))
+		conditions = append(conditions, tagTreeCondition(len(args)))
+	}
+	rows, err := config.DB.Query(fmt.Sprintf(`
+		SELECT
+			p.year,
+			COUNT(*),
+			(ARRAY_AGG(p.id ORDER BY %[1]s))[1:%[2]d],
+			(ARRAY_AGG(p.minio_url ORDER BY %[1]s))[1:%[2]d]
+		FROM posts p
+		WHERE %[3]s
+		GROUP BY p.year
+		ORDER BY p.year;
+		`, chronologicalOrder, thumbnailsPerYear, strings.Join(conditions, " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	decades := make([]timelineDecade, 0)
+	for rows.Next() {
+		year := timelineYear{}
+		var ids []int64
+		var urls []string
+		err := rows.Scan(&year.Year, &year.Count, pq.Array(&ids), pq.Array(&urls))
+		if err != nil {
+			return decades, err
+		}
+		for i := range ids {
+			year.Thumbnails = append(year.Thumbnails, thumbnail{Id: int(ids[i]), ImageURL: urls[i]})
+		}
+		start := year.Year - year.Year%10
+		if len(decades) == 0 || decades[len(decades)-1].Start != start {
+			decades = append(decades, timelineDecade{Start: start})
+		}
+		current := &decades[len(decades)-1]
+		current.Count += year.Count
+		current.Years = append(current.Years, year)
+	}
+	return decades, rows.Err()
+}
@@ -0,0 +1,445 @@
+package posts
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"project/server/config"
+	"project/server/imagemeta"
+	"project/server/objects"
+	"project/server/users"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/minio/minio-go"
+	uuid "github.com/satori/go.uuid"
+)
+
+// The resumable upload endpoint implements the core tus protocol
+// (https://tus.io/protocols/resumable-upload) with the creation, expiration
+// and termination extensions. Every PATCH is stored as one part of a MinIO
+// multipart upload, so a dropped connection only loses the chunk in flight.
+
+const tusVersion = "1.0.0"
+
+// minChunkSize is the smallest part MinIO accepts for all but the last part
+// of a multipart upload.
+const minChunkSize = 5 << 20
+
+type tusUpload struct {
+	Id          string
+	UserId      int
+	ObjectName  string
+	MultipartId string
+	Length      int64
+	Offset      int64
+	ETags       []string
+	Metadata    string
+	PostId      sql.NullInt64
+	// Completed is set once the post of the upload has been created.
+	Completed bool
+	CreatedAt time.Time
+}
+
+// Expires is when an upload that is not completed by then is removed.
+func (upload tusUpload) Expires() time.Time {
+	return upload.CreatedAt.Add(config.TusUploadExpiry)
+}
+
+func TusHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if req.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,expiration,termination")
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(config.MaxFileSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	userId, loggedIn := users.GetLoginStatus(req)
+	if !loggedIn {
+		http.Error(w, "Permission denied.", http.StatusUnauthorized)
+		return
+	}
+	if req.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	id := req.URL.Path[len("/files/"):]
+	if id == "" {
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		createTusUploadHandler(w, req, *userId)
+		return
+	}
+	upload, err := getTusUpload(id, *userId)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error loading upload", http.StatusInternalServerError)
+		return
+	}
+	switch req.Method {
+	case http.MethodHead:
+		if !completeStalledTusUpload(w, upload) {
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		patchTusUploadHandler(w, req, upload)
+	case http.MethodDelete:
+		err = deleteTusUpload(upload)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "error terminating upload", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func createTusUploadHandler(w http.ResponseWriter, req *http.Request, userId int) {
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if length > config.MaxFileSize {
+		http.Error(w, fmt.Sprintf("upload exceeds the maximum of %d bytes", config.MaxFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseTusMetadata(req.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	if _, err := parseDateField(metadata["date"], metadata["year"]); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := createTusUpload(userId, length, req.Header.Get("Upload-Metadata"))
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error creating upload", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/files/"+id)
+	w.Header().Set("Upload-Expires", time.Now().Add(config.TusUploadExpiry).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func patchTusUploadHandler(w http.ResponseWriter, req *http.Request, upload tusUpload) {
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if upload.Offset == upload.Length {
+		if !completeStalledTusUpload(w, upload) {
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.ContentLength < 0 {
+		http.Error(w, "chunks need a Content-Length", http.StatusLengthRequired)
+		return
+	}
+	end := offset + req.ContentLength
+	if end > upload.Length {
+		http.Error(w, "chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if end < upload.Length && req.ContentLength < minChunkSize {
+		http.Error(w, fmt.Sprintf("chunks must be at least %d bytes", minChunkSize), http.StatusBadRequest)
+		return
+	}
+	body := bufio.NewReaderSize(req.Body, 512)
+	if offset == 0 {
+		// Reject anything but images before storing a single byte.
+		if _, err := detectFormat(body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	upload, err = appendTusChunk(upload, body, req.ContentLength)
+	if err == errTusOffsetConflict {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "error storing chunk", http.StatusInternalServerError)
+		return
+	}
+	if !completeStalledTusUpload(w, upload) {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeStalledTusUpload creates the post of an upload that has all its
+// bytes but no post yet, which also retries a completion that failed before.
+// It reports an error and returns false when the post can not be created.
+func completeStalledTusUpload(w http.ResponseWriter, upload tusUpload) bool {
+	if upload.Offset < upload.Length || upload.Completed {
+		return true
+	}
+	if _, err := completeTusUpload(upload); err != nil {
+		log.Println(err)
+		http.Error(w, "error creating post", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated keys,
+// each followed by a space and a base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func createTusUpload(userId int, length int64, metadata string) (string, error) {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		return "", fmt.Errorf("error creating minio client: %v", err)
+	}
+	id := uuid.NewV4().String()
+	objectName := "uploads/" + id
+	core := minio.Core{Client: minioClient}
+	multipartId, err := core.NewMultipartUpload(objects.Bucket, objectName, minio.PutObjectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error starting multipart upload: %v", err)
+	}
+	_, err = config.DB.Exec(`
+		INSERT INTO tus_uploads (ID, USER_ID, OBJECT_NAME, MULTIPART_ID, UPLOAD_LENGTH, METADATA)
+		VALUES ($1, $2, $3, $4, $5, $6);`,
+		id, userId, objectName, multipartId, length, metadata)
+	if err != nil {
+		core.AbortMultipartUpload(objects.Bucket, objectName, multipartId)
+		return "", err
+	}
+	return id, nil
+}
+
+func getTusUpload(id string, userId int) (tusUpload, error) {
+	upload := tusUpload{}
+	if _, err := uuid.FromString(id); err != nil {
+		return upload, sql.ErrNoRows
+	}
+	err := config.DB.QueryRow(`
+		SELECT id, user_id, object_name, multipart_id, upload_length, upload_offset, etags, metadata, post_id, completed_at IS NOT NULL, created_at
+		FROM tus_uploads
+		WHERE id=$1 AND user_id=$2 AND (completed_at IS NOT NULL OR created_at >= $3);
+	`, id, userId, time.Now().Add(-config.TusUploadExpiry)).Scan(&upload.Id, &upload.UserId, &upload.ObjectName, &upload.MultipartId, &upload.Length, &upload.Offset, pq.Array(&upload.ETags), &upload.Metadata, &upload.PostId, &upload.Completed, &upload.CreatedAt)
+	return upload, err
+}
+
+// errTusOffsetConflict is returned when another request appended to the
+// upload first.
+var errTusOffsetConflict = errors.New("upload offset changed concurrently")
+
+// appendTusChunk stores chunk as the next part of the multipart upload and
+// advances the offset. The row stays locked while the part is stored, so a
+// concurrent request at the same offset can not overwrite the part.
+func appendTusChunk(upload tusUpload, chunk io.Reader, size int64) (tusUpload, error) {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		return upload, fmt.Errorf("error creating minio client: %v", err)
+	}
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return upload, err
+	}
+	defer tx.Rollback()
+	var offset int64
+	var etags []string
+	err = tx.QueryRow("SELECT upload_offset, etags FROM tus_uploads WHERE id=$1 FOR UPDATE;", upload.Id).Scan(&offset, pq.Array(&etags))
+	if err != nil {
+		return upload, err
+	}
+	if offset != upload.Offset || len(etags) != len(upload.ETags) {
+		return upload, errTusOffsetConflict
+	}
+	core := minio.Core{Client: minioClient}
+	part, err := core.PutObjectPart(objects.Bucket, upload.ObjectName, upload.MultipartId, len(upload.ETags)+1, chunk, size, "", "", nil)
+	if err != nil {
+		return upload, fmt.Errorf("error storing part on S3: %v", err)
+	}
+	_, err = tx.Exec(`
+		UPDATE tus_uploads
+		SET UPLOAD_OFFSET=$1, ETAGS=array_append(ETAGS, $2)
+		WHERE ID=$3;
+		`, upload.Offset+size, part.ETag, upload.Id)
+	if err != nil {
+		return upload, err
+	}
+	if err := tx.Commit(); err != nil {
+		return upload, err
+	}
+	upload.Offset += size
+	upload.ETags = append(upload.ETags, part.ETag)
+	return upload, nil
+}
+
+// completeTusUpload assembles the parts and hands the object to the same post
+// creation and tagging logic as storeFiles. The parts may already have been
+// assembled by an earlier attempt that failed afterwards.
+func completeTusUpload(upload tusUpload) (*int, error) {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		return nil, fmt.Errorf("error creating minio client: %v", err)
+	}
+	core := minio.Core{Client: minioClient}
+	parts := make([]minio.CompletePart, len(upload.ETags))
+	for i, etag := range upload.ETags {
+		parts[i] = minio.CompletePart{PartNumber: i + 1, ETag: etag}
+	}
+	_, err = core.CompleteMultipartUpload(objects.Bucket, upload.ObjectName, upload.MultipartId, parts)
+	if err != nil {
+		if _, statErr := minioClient.StatObject(objects.Bucket, upload.ObjectName, minio.StatObjectOptions{}); statErr != nil {
+			return nil, fmt.Errorf("error completing multipart upload: %v", err)
+		}
+	}
+	metadata, err := parseTusMetadata(upload.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	date, err := parseDateField(metadata["date"], metadata["year"])
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := minioClient.GetObject(objects.Bucket, upload.ObjectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading assembled upload: %v", err)
+	}
+	defer object.Close()
+	src := bufio.NewReaderSize(object, imagemeta.HeaderSize)
+	contentType, err := detectFormat(src)
+	if err != nil {
+		minioClient.RemoveObject(objects.Bucket, upload.ObjectName)
+		return nil, err
+	}
+	location := exifLocation(src)
+	h := sha1.New()
+	if _, err := io.Copy(h, src); err != nil {
+		return nil, fmt.Errorf("error hashing assembled upload: %v", err)
+	}
+	postId, err := createPostFromObject(minioClient, upload.ObjectName, contentType, fmt.Sprintf("%x", h.Sum(nil)), date, location, parseTags(metadata["tags"]), upload.UserId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = config.DB.Exec("UPDATE tus_uploads SET POST_ID=$1, COMPLETED_AT=NOW() WHERE ID=$2;", *postId, upload.Id)
+	return postId, err
+}
+
+// deleteTusUpload forgets an upload along with what it left in the bucket:
+// the parts received so far, or the assembled object when creating its post
+// failed.
+func deleteTusUpload(upload tusUpload) error {
+	if !upload.Completed {
+		minioClient, err := config.NewMinIO()
+		if err != nil {
+			return fmt.Errorf("error creating minio client: %v", err)
+		}
+		core := minio.Core{Client: minioClient}
+		err = core.AbortMultipartUpload(objects.Bucket, upload.ObjectName, upload.MultipartId)
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchUpload" {
+			return fmt.Errorf("error aborting multipart upload: %v", err)
+		}
+		// RemoveObject succeeds when nothing was assembled
+		if err := minioClient.RemoveObject(objects.Bucket, upload.ObjectName); err != nil {
+			return fmt.Errorf("error removing assembled upload: %v", err)
+		}
+	}
+	_, err := config.DB.Exec("DELETE FROM tus_uploads WHERE ID=$1;", upload.Id)
+	return err
+}
+
+// expireTusUploads deletes the uploads older than expiry and returns how
+// many of them were abandoned before their post was created.
+func expireTusUploads(expiry time.Duration) (int, error) {
+	var abandoned int
+	rows, err := config.DB.Query(`
+		SELECT id, object_name, multipart_id, completed_at IS NOT NULL
+		FROM tus_uploads
+		WHERE created_at < $1;
+	`, time.Now().Add(-expiry))
+	if err != nil {
+		return abandoned, err
+	}
+	var uploads []tusUpload
+	for rows.Next() {
+		upload := tusUpload{}
+		if err := rows.Scan(&upload.Id, &upload.ObjectName, &upload.MultipartId, &upload.Completed); err != nil {
+			rows.Close()
+			return abandoned, err
+		}
+		uploads = append(uploads, upload)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return abandoned, err
+	}
+	for _, upload := range uploads {
+		if err := deleteTusUpload(upload); err != nil {
+			return abandoned, err
+		}
+		if !upload.Completed {
+			abandoned++
+		}
+	}
+	return abandoned, nil
+}
+
+// ExpireTusUploadsPeriodically cleans up expired uploads every hour.
+func ExpireTusUploadsPeriodically(expiry time.Duration) {
+	for {
+		abandoned, err := expireTusUploads(expiry)
+		if err != nil {
+			log.Printf("error expiring uploads: %v", err)
+		} else if abandoned > 0 {
+			log.Printf("removed %d abandoned uploads", abandoned)
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,52 @@
+package posts
+
+import (
+	"io"
+	"project/server/config"
+	"project/server/imagemeta"
+	"project/server/objects"
+
+	"github.com/minio/minio-go"
+)
+
+// postXMP describes a post, its rights, the people in it and the negative it
+// was scanned from as XMP. Width and height are the size of the image, zero
+// when unknown.
+func postXMP(post Post, regions []Region, width, height int) imagemeta.XMP {
+	x := rightsXMP(post)
+	x.Keywords = post.Tags
+	x.Width, x.Height = width, height
+	x.Source = post.Provenance.Reference()
+	x.CreatorTool = post.Provenance.Scanner
+	x.Digitized = post.Provenance.ScannedOn
+	x.Resolution = post.Provenance.Resolution
+	for _, region := range regions {
+		x.Regions = append(x.Regions, imagemeta.Region{
+			Name:   region.PersonName,
+			Type:   regionType,
+			X:      region.X,
+			Y:      region.Y,
+			Width:  region.Width,
+			Height: region.Height,
+		})
+	}
+	return x
+}
+
+// imageDimensions reads the pixel size of a post's image from its header.
+func imageDimensions(objectName string) (int, int, error) {
+	minioClient, err := config.NewMinIO()
+	if err != nil {
+		return 0, 0, err
+	}
+	object, err := minioClient.GetObject(objects.Bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer object.Close()
+	header, err := io.ReadAll(io.LimitReader(object, imagemeta.HeaderSize))
+	if err != nil {
+		return 0, 0, err
+	}
+	return imagemeta.Dimensions(header)
+}